@@ -0,0 +1,93 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"os/exec"
+	"syscall"
+	"time"
+
+	"github.com/odedniv/osafe/go/pkg/agent"
+	"github.com/odedniv/osafe/go/pkg/encryption"
+)
+
+var agentStartTimeout = time.Second * 5
+
+func runAgent(args []string) error {
+	flags := flag.NewFlagSet("agent", flag.ContinueOnError)
+	timeout := flags.Duration("timeout", time.Minute*15, "forget the key after being idle for this long, 0 to keep it until `osafe lock`")
+	foreground := flags.Bool("foreground", false, "run in the foreground instead of in the background")
+	if err := flags.Parse(args); err != nil {
+		return err
+	} else if flags.NArg() > 0 {
+		return fmt.Errorf("unexpected arguments: %v", flags.Args())
+	}
+
+	if *foreground {
+		return agent.Serve(*timeout)
+	}
+	// Starting in the background, detached from the terminal
+	self, err := os.Executable()
+	if err != nil {
+		return fmt.Errorf("failed getting executable for agent: %v", err)
+	}
+	cmd := exec.Command(self, "agent", "-foreground", "-timeout", timeout.String())
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setsid: true}
+	if err := cmd.Start(); err != nil {
+		return fmt.Errorf("failed starting agent: %v", err)
+	}
+	exited := make(chan error, 1)
+	go func() { exited <- cmd.Wait() }()
+	// Waiting for it to listen
+	for deadline := time.Now().Add(agentStartTimeout); time.Now().Before(deadline); {
+		select {
+		case err := <-exited:
+			return fmt.Errorf("agent exited: %v", err)
+		case <-time.After(time.Millisecond * 50):
+		}
+		if _, err := agent.Get(); err == nil {
+			fmt.Printf("Agent started (pid %d).\n", cmd.Process.Pid)
+			return nil
+		}
+	}
+	return errors.New("timed out waiting for agent to start")
+}
+
+func runLock(args []string) error {
+	if len(args) > 0 {
+		return fmt.Errorf("unexpected arguments: %v", args)
+	}
+	err := agent.Lock()
+	if errors.Is(err, agent.ErrNotRunning) {
+		fmt.Println("No agent running.")
+		return nil
+	} else if err != nil {
+		return err
+	}
+	fmt.Println("Agent locked.")
+	return nil
+}
+
+// decryptWithAgent decrypts with the key kept by the agent, returning false when there's no agent,
+// it's locked, or it has the key of another vault.
+func decryptWithAgent(m encryption.Message) (encryption.DecryptedMessage, bool) {
+	key, err := agent.Get()
+	if err != nil || key == nil {
+		return encryption.DecryptedMessage{}, false
+	}
+	dm, err := m.DecryptBaseKey(key)
+	if err != nil {
+		return encryption.DecryptedMessage{}, false
+	}
+	return dm, true
+}
+
+// rememberInAgent keeps the key in the agent when one is running.
+func rememberInAgent(dm encryption.DecryptedMessage) {
+	err := agent.Set(dm.BaseKey())
+	if err != nil && !errors.Is(err, agent.ErrNotRunning) {
+		fmt.Fprintf(os.Stderr, "Warning: failed keeping key in agent: %v\n", err)
+	}
+}
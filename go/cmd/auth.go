@@ -0,0 +1,49 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/odedniv/osafe/go/pkg/storage"
+)
+
+func runAuth(args []string) error {
+	if len(args) != 1 {
+		return errors.New("usage: osafe auth status|login|logout")
+	}
+	switch args[0] {
+	case "status":
+		return runAuthStatus()
+	case "login":
+		if err := storage.DriveLogin(); err != nil {
+			return err
+		}
+		fmt.Println("Logged in to Google Drive.")
+		return nil
+	case "logout":
+		if err := storage.DriveLogout(); err != nil {
+			return err
+		}
+		fmt.Println("Logged out of Google Drive.")
+		return nil
+	default:
+		return fmt.Errorf("unknown auth command: %s", args[0])
+	}
+}
+
+func runAuthStatus() error {
+	auth, err := storage.DriveAuthStatus()
+	if err != nil {
+		return err
+	}
+	switch {
+	case !auth.LoggedIn:
+		fmt.Println("Google Drive: logged out, run `osafe auth login`.")
+	case auth.Err != nil:
+		fmt.Printf("Google Drive: authorization invalid, run `osafe auth login`: %v\n", auth.Err)
+	default:
+		fmt.Printf("Google Drive: logged in, access token expires %s.\n", auth.Expiry.Local().Format(time.DateTime))
+	}
+	return nil
+}
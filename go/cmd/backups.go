@@ -0,0 +1,85 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/odedniv/osafe/go/pkg/merge"
+	"github.com/odedniv/osafe/go/pkg/storage"
+)
+
+func runBackups(args []string) error {
+	if len(args) == 0 {
+		return errors.New("usage: osafe backups ls|restore <backup>")
+	}
+	switch args[0] {
+	case "ls":
+		if len(args) > 1 {
+			return fmt.Errorf("unexpected arguments: %v", args[1:])
+		}
+		return runBackupsLs()
+	case "restore":
+		if len(args) != 2 {
+			return errors.New("usage: osafe backups restore <backup>")
+		}
+		return runBackupsRestore(args[1])
+	default:
+		return fmt.Errorf("unknown backups command: %s", args[0])
+	}
+}
+
+func runBackupsLs() error {
+	backups, err := storage.Backups()
+	if err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "BACKUP\tCREATED\tSIZE")
+	for _, b := range backups {
+		fmt.Fprintf(w, "%s\t%s\t%d\n", b.Id, b.CreatedTime.Local().Format(time.DateTime), b.Size)
+	}
+	return w.Flush()
+}
+
+func runBackupsRestore(id string) error {
+	// Reading backup first, as reading storages may create another backup
+	bm, err := storage.ReadBackup(id)
+	if err != nil {
+		return err
+	}
+	// Reading current
+	m, err := storage.Read()
+	if err != nil {
+		return err
+	} else if m == nil {
+		return errors.New("no vault to restore to")
+	}
+	dm, err := decrypt(*m)
+	if err != nil {
+		return err
+	}
+	backup, err := dm.Decrypt(*bm)
+	if err != nil {
+		fmt.Println("Backup was encrypted with a different key.")
+		if backup, err = decrypt(*bm); err != nil {
+			return err
+		}
+	}
+	// Preview
+	fmt.Printf("----- Current vs. backup %s -----\n", id)
+	os.Stdout.Write(merge.Diff(dm.Content, backup.Content))
+	fmt.Println("-----")
+	if readChoice("Restore this backup? [y/n] ", "y", "n") == "n" {
+		return nil
+	}
+	// Write, keeping the current keys
+	dm, err = dm.WithContent(backup.Content)
+	if err != nil {
+		return err
+	}
+	return storage.Write(dm.Message)
+}
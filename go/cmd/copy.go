@@ -0,0 +1,252 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+	"syscall"
+	"time"
+)
+
+var clipboardNames = []string{"wl-copy", "xclip", "xsel", "osc52"}
+var clearClipCommand = "clear-clip" // Not listed in commands, as it's only started by copy.
+
+// clipboard is a way to reach the user's clipboard.
+type clipboard interface {
+	name() string
+	write(content []byte) error
+	// read returns the clipboard's content, or errClipboardUnreadable.
+	read() ([]byte, error)
+	clear() error
+}
+
+var errClipboardUnreadable = errors.New("clipboard can't be read")
+
+// commandClipboard uses external commands, e.g. xclip.
+type commandClipboard struct {
+	copyArgs  []string
+	pasteArgs []string
+	clearArgs []string // Copying empty content when nil.
+}
+
+// osc52Clipboard asks the terminal to set its clipboard, which also works over SSH. Terminals don't
+// reliably answer reading it back, and the answer would go to whatever reads the terminal, so it's
+// never cleared as that could clear something the user copied since.
+type osc52Clipboard struct {
+	tty *os.File
+}
+
+func runCopy(args []string) error {
+	flags := flag.NewFlagSet("copy", flag.ContinueOnError)
+	lf := addLookupFlags(flags)
+	clearAfter := flags.Duration("clear", time.Second*45, "clear the clipboard after this long if it wasn't changed since, 0 to keep it")
+	via := flags.String("via", "", "clipboard to use, one of: "+strings.Join(clipboardNames, ", ")+" (default is detected)")
+	if err := flags.Parse(args); err != nil {
+		return err
+	} else if flags.NArg() == 0 {
+		return errors.New("missing query")
+	}
+
+	// Looking up
+	found, err := lf.lookup(strings.Join(flags.Args(), " "))
+	if err != nil {
+		return err
+	}
+	// Copying
+	cb, err := detectClipboard(*via)
+	if err != nil {
+		return err
+	}
+	if err := cb.write([]byte(found)); err != nil {
+		return err
+	}
+	if *clearAfter == 0 {
+		fmt.Printf("Copied to clipboard (%s).\n", cb.name())
+		return nil
+	}
+	current, err := cb.read()
+	clear(current)
+	if errors.Is(err, errClipboardUnreadable) {
+		fmt.Printf("Copied to clipboard (%s), not clearing it as it can't be checked for changes since, clear it yourself.\n", cb.name())
+		return nil
+	}
+	// Clearing in the background
+	if err := startClearClipboard(cb, []byte(found), *clearAfter); err != nil {
+		return err
+	}
+	fmt.Printf("Copied to clipboard (%s), clearing in %v.\n", cb.name(), *clearAfter)
+	return nil
+}
+
+// runClearClip waits and clears the clipboard if it still holds the content read from stdin,
+// started by copy.
+func runClearClip(args []string) error {
+	flags := flag.NewFlagSet(clearClipCommand, flag.ContinueOnError)
+	after := flags.Duration("after", 0, "clear after this long")
+	via := flags.String("via", "", "clipboard to clear")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	content, err := io.ReadAll(os.Stdin)
+	if err != nil {
+		return fmt.Errorf("failed reading copied content: %v", err)
+	}
+	defer clear(content)
+	cb, err := detectClipboard(*via)
+	if err != nil {
+		return err
+	}
+
+	time.Sleep(*after)
+	_, err = clearUnchanged(cb, content)
+	return err
+}
+
+// clearUnchanged clears the clipboard if it still holds content, returning whether it did.
+func clearUnchanged(cb clipboard, content []byte) (bool, error) {
+	current, err := cb.read()
+	defer clear(current)
+	if err != nil || !bytes.Equal(current, content) {
+		return false, nil // Unreadable, changed since, or emptied.
+	}
+	return true, cb.clear()
+}
+
+func startClearClipboard(cb clipboard, content []byte, after time.Duration) error {
+	self, err := os.Executable()
+	if err != nil {
+		return fmt.Errorf("failed getting executable for clearing clipboard: %v", err)
+	}
+	cmd := exec.Command(self, clearClipCommand, "-after", after.String(), "-via", cb.name())
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setsid: true} // Outliving the terminal.
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return fmt.Errorf("failed piping to clipboard clearing: %v", err)
+	}
+	if err := cmd.Start(); err != nil {
+		return fmt.Errorf("failed starting clipboard clearing: %v", err)
+	}
+	defer cmd.Process.Release()
+	if _, err := stdin.Write(content); err != nil {
+		return fmt.Errorf("failed piping to clipboard clearing: %v", err)
+	}
+	return stdin.Close()
+}
+
+// detectClipboard returns the clipboard by name, or the first available of Wayland, X11 and the
+// terminal when name is empty.
+func detectClipboard(name string) (clipboard, error) {
+	wayland := os.Getenv("WAYLAND_DISPLAY") != ""
+	x11 := os.Getenv("DISPLAY") != ""
+	for _, n := range clipboardNames {
+		if name != "" && n != name {
+			continue
+		}
+		var cb clipboard
+		switch n {
+		case "wl-copy":
+			if name == "" && !wayland {
+				continue
+			}
+			cb = &commandClipboard{
+				copyArgs:  []string{"wl-copy"},
+				pasteArgs: []string{"wl-paste", "--no-newline"},
+				clearArgs: []string{"wl-copy", "--clear"},
+			}
+		case "xclip":
+			if name == "" && !x11 {
+				continue
+			}
+			cb = &commandClipboard{
+				copyArgs:  []string{"xclip", "-selection", "clipboard", "-in"},
+				pasteArgs: []string{"xclip", "-selection", "clipboard", "-out"},
+			}
+		case "xsel":
+			if name == "" && !x11 {
+				continue
+			}
+			cb = &commandClipboard{
+				copyArgs:  []string{"xsel", "--clipboard", "--input"},
+				pasteArgs: []string{"xsel", "--clipboard", "--output"},
+				clearArgs: []string{"xsel", "--clipboard", "--clear"},
+			}
+		case "osc52":
+			tty, err := os.OpenFile("/dev/tty", os.O_WRONLY, 0)
+			if err != nil {
+				if name != "" {
+					return nil, fmt.Errorf("failed opening terminal for clipboard: %v", err)
+				}
+				continue
+			}
+			return &osc52Clipboard{tty: tty}, nil
+		}
+		if _, err := exec.LookPath(cb.(*commandClipboard).copyArgs[0]); err != nil {
+			if name != "" {
+				return nil, fmt.Errorf("clipboard command not found: %v", err)
+			}
+			continue
+		}
+		return cb, nil
+	}
+	if name != "" {
+		return nil, fmt.Errorf("unknown clipboard: %s", name)
+	}
+	return nil, errors.New("no clipboard found, install wl-clipboard, xclip or xsel, or use a terminal")
+}
+
+func (c *commandClipboard) name() string {
+	return c.copyArgs[0]
+}
+
+func (c *commandClipboard) write(content []byte) error {
+	cmd := exec.Command(c.copyArgs[0], c.copyArgs[1:]...)
+	cmd.Stdin = bytes.NewReader(content)
+	cmd.Stderr = os.Stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("failed copying with %s: %v", c.name(), err)
+	}
+	return nil
+}
+
+func (c *commandClipboard) read() ([]byte, error) {
+	content, err := exec.Command(c.pasteArgs[0], c.pasteArgs[1:]...).Output()
+	if err != nil {
+		return nil, fmt.Errorf("failed pasting with %s: %v", c.pasteArgs[0], err)
+	}
+	return content, nil
+}
+
+func (c *commandClipboard) clear() error {
+	if c.clearArgs == nil {
+		return c.write(nil)
+	}
+	if err := exec.Command(c.clearArgs[0], c.clearArgs[1:]...).Run(); err != nil {
+		return fmt.Errorf("failed clearing with %s: %v", c.name(), err)
+	}
+	return nil
+}
+
+func (o *osc52Clipboard) name() string {
+	return "osc52"
+}
+
+func (o *osc52Clipboard) write(content []byte) error {
+	if _, err := fmt.Fprintf(o.tty, "\x1b]52;c;%s\a", base64.StdEncoding.EncodeToString(content)); err != nil {
+		return fmt.Errorf("failed copying with terminal: %v", err)
+	}
+	return nil
+}
+
+func (o *osc52Clipboard) read() ([]byte, error) {
+	return nil, errClipboardUnreadable
+}
+
+func (o *osc52Clipboard) clear() error {
+	return o.write(nil)
+}
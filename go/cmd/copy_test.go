@@ -0,0 +1,104 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path"
+	"testing"
+)
+
+// fakeClipboard holds content in memory, failing to read with readErr when it's set.
+type fakeClipboard struct {
+	content []byte
+	readErr error
+	cleared bool
+}
+
+func (c *fakeClipboard) name() string {
+	return "fake"
+}
+
+func (c *fakeClipboard) write(content []byte) error {
+	c.content = content
+	return nil
+}
+
+func (c *fakeClipboard) read() ([]byte, error) {
+	if c.readErr != nil {
+		return nil, c.readErr
+	}
+	return bytes.Clone(c.content), nil
+}
+
+func (c *fakeClipboard) clear() error {
+	c.content = nil
+	c.cleared = true
+	return nil
+}
+
+func TestClearUnchanged(t *testing.T) {
+	tests := []struct {
+		name    string
+		current string
+		readErr error
+		want    bool
+	}{
+		{name: "unchanged", current: "secret", want: true},
+		{name: "changed", current: "other"},
+		{name: "emptied", current: ""},
+		{name: "unreadable", current: "secret", readErr: errClipboardUnreadable},
+		{name: "read failure", current: "secret", readErr: errors.New("failed pasting")},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cb := &fakeClipboard{content: []byte(tt.current), readErr: tt.readErr}
+			got, err := clearUnchanged(cb, []byte("secret"))
+			if err != nil || got != tt.want || cb.cleared != tt.want {
+				t.Errorf("clearUnchanged() = %v, %v, cleared: %v, want %v", got, err, cb.cleared, tt.want)
+			}
+		})
+	}
+}
+
+func TestDetectClipboard(t *testing.T) {
+	tests := []struct {
+		name     string
+		via      string
+		wayland  string // WAYLAND_DISPLAY
+		x11      string // DISPLAY
+		commands []string
+		want     string
+		wantErr  bool
+	}{
+		{name: "wayland", wayland: "wayland-0", x11: ":0", commands: []string{"wl-copy", "xclip"}, want: "wl-copy"},
+		{name: "wayland without wl-copy", wayland: "wayland-0", x11: ":0", commands: []string{"xclip"}, want: "xclip"},
+		{name: "x11", x11: ":0", commands: []string{"wl-copy", "xsel"}, want: "xsel"},
+		{name: "x11 prefers xclip", x11: ":0", commands: []string{"xclip", "xsel"}, want: "xclip"},
+		{name: "named without display", via: "xsel", commands: []string{"xsel"}, want: "xsel"},
+		{name: "named missing", via: "xclip", x11: ":0", commands: []string{"xsel"}, wantErr: true},
+		{name: "unknown", via: "pbcopy", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			for _, c := range tt.commands {
+				if err := os.WriteFile(path.Join(dir, c), []byte("#!/bin/sh\n"), 0700); err != nil {
+					t.Fatal(err)
+				}
+			}
+			t.Setenv("PATH", dir)
+			t.Setenv("WAYLAND_DISPLAY", tt.wayland)
+			t.Setenv("DISPLAY", tt.x11)
+
+			cb, err := detectClipboard(tt.via)
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("detectClipboard() = %s, want an error", cb.name())
+				}
+			} else if err != nil || cb.name() != tt.want {
+				t.Errorf("detectClipboard() = %v, %v, want %s", cb, err, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,171 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/odedniv/osafe/go/pkg/config"
+	"github.com/odedniv/osafe/go/pkg/merge"
+	"github.com/odedniv/osafe/go/pkg/storage"
+)
+
+func runDriveHistory(args []string) error {
+	if len(args) > 0 {
+		return fmt.Errorf("unexpected arguments: %v", args)
+	}
+	revisions, err := storage.DriveRevisions()
+	if err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "REVISION\tMODIFIED\tSIZE")
+	for _, r := range revisions {
+		fmt.Fprintf(w, "%s\t%s\t%d\n", r.Id, r.ModifiedTime.Local().Format(time.DateTime), r.Size)
+	}
+	return w.Flush()
+}
+
+func runDriveRestore(args []string) error {
+	if len(args) != 1 {
+		return errors.New("usage: osafe drive-restore <revision>")
+	}
+	// Reading current
+	m, err := storage.Read()
+	if err != nil {
+		return err
+	} else if m == nil {
+		return errors.New("no vault to restore to")
+	}
+	dm, err := decrypt(*m)
+	if err != nil {
+		return err
+	}
+	// Reading revision
+	rm, err := storage.ReadDriveRevision(args[0])
+	if err != nil {
+		return err
+	}
+	revision, err := dm.Decrypt(*rm)
+	if err != nil {
+		return fmt.Errorf("failed decrypting revision with the current vault key: %v", err)
+	}
+	// Preview
+	fmt.Printf("----- Revision %s -----\n", args[0])
+	os.Stdout.Write(ensureNewline(revision.Content))
+	fmt.Println("-----")
+	if readChoice("Restore this revision? [y/n] ", "y", "n") == "n" {
+		return nil
+	}
+	// Write, keeping the current keys
+	dm, err = dm.WithContent(revision.Content)
+	if err != nil {
+		return err
+	}
+	return storage.Write(dm.Message)
+}
+
+func runDriveMigrate(args []string) error {
+	var from config.Drive
+	flags := flag.NewFlagSet("drive-migrate", flag.ContinueOnError)
+	flags.StringVar(&from.FolderId, "from-folder-id", "", "ID of the folder to migrate from, defaults to the root of My Drive")
+	flags.StringVar(&from.FolderPath, "from-folder-path", "", "path of the folder to migrate from")
+	flags.StringVar(&from.Filename, "from-filename", "", "name of the vault file to migrate from, defaults to osafe.json")
+	flags.BoolVar(&from.AppData, "from-app-data", false, "migrate from the hidden application data folder")
+	if err := flags.Parse(args); err != nil {
+		return err
+	} else if flags.NArg() > 0 {
+		return fmt.Errorf("unexpected arguments: %v", flags.Args())
+	}
+
+	if err := storage.DriveMigrate(from); err != nil {
+		return err
+	}
+	fmt.Println("Migrated vault to the configured Drive location, the old one was moved to trash.")
+	return nil
+}
+
+func runDriveDedupe(args []string) error {
+	if len(args) > 0 {
+		return fmt.Errorf("unexpected arguments: %v", args)
+	}
+	// Reading, which also syncs the newest vault to Drive
+	m, err := storage.Read()
+	if err != nil {
+		return err
+	} else if m == nil {
+		return errors.New("no vault to dedupe")
+	}
+	ds, err := storage.DriveDuplicates()
+	if err != nil {
+		return err
+	} else if len(ds) < 2 {
+		fmt.Println("No duplicates in Drive.")
+		return nil
+	}
+	// Decrypting and showing differences
+	fmt.Printf("Found %d vault files in Drive, newest from %s.\n", len(ds), ds[0].ModifiedTime.Local().Format(time.DateTime))
+	newest, err := decrypt(ds[0].Message)
+	if err != nil {
+		return err
+	}
+	var duplicates [][]byte
+	for _, d := range ds[1:] {
+		dm, err := newest.Decrypt(d.Message)
+		if err != nil {
+			fmt.Printf("Duplicate from %s was encrypted with a different key.\n", d.ModifiedTime.Local().Format(time.DateTime))
+			dm, err = decrypt(d.Message)
+			if err != nil {
+				return err
+			}
+		}
+		fmt.Printf("----- Newest vs. duplicate from %s -----\n", d.ModifiedTime.Local().Format(time.DateTime))
+		os.Stdout.Write(merge.Diff(newest.Content, dm.Content))
+		duplicates = append(duplicates, dm.Content)
+	}
+	fmt.Println("-----")
+	// Resolving
+	content := newest.Content
+	switch readChoice("[m]erge all into the newest, [k]eep only the newest, or [a]bort? ", "m", "k", "a") {
+	case "a":
+		return nil
+	case "m":
+		for _, d := range duplicates {
+			content = merge.Union(content, d)
+		}
+		if content, err = edit(content); err != nil {
+			return err
+		}
+	}
+	if !bytes.Equal(content, newest.Content) {
+		dm, err := newest.WithContent(content)
+		if err != nil {
+			return err
+		}
+		if err := storage.Write(dm.Message); err != nil {
+			return err
+		}
+	}
+	// Trashing the rest
+	var ids []string
+	for _, d := range ds[1:] {
+		ids = append(ids, d.Id)
+	}
+	if err := storage.DriveTrash(ids); err != nil {
+		return err
+	}
+	fmt.Printf("Moved %d duplicates to trash.\n", len(ids))
+	return nil
+}
+
+func ensureNewline(content []byte) []byte {
+	if len(content) == 0 || content[len(content)-1] == '\n' {
+		return content
+	}
+	return append(content[:len(content):len(content)], '\n')
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/odedniv/osafe/go/pkg/generate"
+)
+
+func runGenerate(args []string) error {
+	flags := flag.NewFlagSet("generate", flag.ContinueOnError)
+	var typeNames []string
+	for _, t := range generate.Types {
+		typeNames = append(typeNames, t.Name())
+	}
+	typeName := flags.String("type", generate.Words.Name(), "passphrase type, one of: "+strings.Join(typeNames, ", "))
+	length := flags.Int("length", 0, "length in words or characters (default 4 words or 8 characters)")
+	count := flags.Int("count", 1, "how many passphrases to generate")
+	rules := map[generate.Rule]*bool{}
+	for _, r := range generate.Rules {
+		rules[r] = flags.Bool(r.Name(), false, "require "+strings.ToLower(r.String()))
+	}
+	if err := flags.Parse(args); err != nil {
+		return err
+	} else if flags.NArg() > 0 {
+		return fmt.Errorf("unexpected arguments: %v", flags.Args())
+	}
+
+	// Config
+	c := generate.Config{Length: *length}
+	found := false
+	for _, t := range generate.Types {
+		if t.Name() == *typeName {
+			c.Type, found = t, true
+		}
+	}
+	if !found {
+		return fmt.Errorf("unknown passphrase type: %s", *typeName)
+	}
+	if c.Length == 0 {
+		c.Length = c.Type.DefaultLength()
+	}
+	for _, r := range generate.Rules {
+		if *rules[r] {
+			c.Rules = append(c.Rules, r)
+		}
+	}
+	// Generating
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	entropy := generate.Entropy(c)
+	for range *count {
+		passphrase, err := generate.Passphrase(c)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(w, "%s\t~%.0f bits\n", passphrase, entropy)
+	}
+	return w.Flush()
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/odedniv/osafe/go/pkg/search"
+	"golang.org/x/term"
+)
+
+// lookupFlags are the flags of commands that look up an entry in the vault.
+type lookupFlags struct {
+	value     *bool
+	separator *string
+	section   *bool
+}
+
+func runGet(args []string) error {
+	flags := flag.NewFlagSet("get", flag.ContinueOnError)
+	lf := addLookupFlags(flags)
+	if err := flags.Parse(args); err != nil {
+		return err
+	} else if flags.NArg() == 0 {
+		return errors.New("missing query")
+	}
+
+	stdout := promptsToStderr()
+	found, err := lf.lookup(strings.Join(flags.Args(), " "))
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintln(stdout, found)
+	return err
+}
+
+func addLookupFlags(flags *flag.FlagSet) lookupFlags {
+	return lookupFlags{
+		value:     flags.Bool("value", false, "only the value: the text after the separator in a line, or the lines after the first in a section"),
+		separator: flags.String("separator", ":", "separates a line's key from its value"),
+		section:   flags.Bool("section", false, "match sections separated by blank lines instead of lines"),
+	}
+}
+
+// lookup fuzzy-matches the query with the vault's lines or sections, asking which to use when several
+// match.
+func (lf lookupFlags) lookup(query string) (string, error) {
+	dm, err := readDecrypted()
+	if err != nil {
+		return "", err
+	}
+	var entries []search.Entry
+	if *lf.section {
+		entries = search.Sections(dm.Content)
+	} else {
+		entries = search.Lines(dm.Content, *lf.separator)
+	}
+	// Matching
+	e, ok := search.Exact(entries, query)
+	if !ok {
+		if e, err = pick(search.Find(entries, query)); err != nil {
+			return "", err
+		}
+	}
+	if !*lf.value {
+		return e.Text, nil
+	} else if !*lf.section && !strings.Contains(e.Text, *lf.separator) {
+		return "", fmt.Errorf("no %q separator in line %d", *lf.separator, e.Line)
+	}
+	return e.Value, nil
+}
+
+// pick returns the only match, or asks which one to use. Only keys are shown, values may be secrets.
+func pick(matches []search.Entry) (search.Entry, error) {
+	switch {
+	case len(matches) == 0:
+		return search.Entry{}, errors.New("no matches")
+	case len(matches) == 1:
+		return matches[0], nil
+	case !term.IsTerminal(int(os.Stdin.Fd())):
+		var keys []string
+		for _, m := range matches {
+			keys = append(keys, m.Key)
+		}
+		return search.Entry{}, fmt.Errorf("%d matches, refine the query: %s", len(matches), strings.Join(keys, ", "))
+	}
+	var choices []string
+	for i, m := range matches {
+		choices = append(choices, strconv.Itoa(i+1))
+		fmt.Printf("%3d) %s (line %d)\n", i+1, m.Key, m.Line)
+	}
+	choice := readChoice(fmt.Sprintf("Choose [1-%d]: ", len(matches)), choices...)
+	i, _ := strconv.Atoi(choice)
+	return matches[i-1], nil
+}
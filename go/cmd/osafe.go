@@ -4,33 +4,108 @@ import (
 	"bytes"
 	"context"
 	"errors"
+	"flag"
 	"fmt"
 	"io"
 	"os"
 	"os/exec"
 	"os/signal"
 	"runtime"
+	"slices"
 	"strings"
 	"syscall"
 	"time"
 
 	"github.com/odedniv/osafe/go/pkg/encryption"
+	"github.com/odedniv/osafe/go/pkg/merge"
 	"github.com/odedniv/osafe/go/pkg/storage"
 	"golang.org/x/term"
 )
 
 var timeout = time.Minute * 5
 
+var commands = map[string]func(args []string) error{
+	"edit":          runEdit,
+	"auth":          runAuth,
+	"drive-history": runDriveHistory,
+	"drive-restore": runDriveRestore,
+	"drive-migrate": runDriveMigrate,
+	"drive-dedupe":  runDriveDedupe,
+	"status":        runStatus,
+	"sync":          runSync,
+	"backups":       runBackups,
+	"show":          runShow,
+	"cat":           runShow,
+	"write":         runWrite,
+	"append":        runAppend,
+	"agent":         runAgent,
+	"lock":          runLock,
+	"get":           runGet,
+	"copy":          runCopy,
+	"generate":      runGenerate,
+}
+
 func main() {
-	if err := run(); err != nil {
-		panic(err)
+	err := run(os.Args[1:])
+	if errors.Is(err, flag.ErrHelp) {
+		return // Usage was printed by the flags.
+	} else if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
+
+func run(args []string) error {
+	// Global flags
+	flags := flag.NewFlagSet("osafe", flag.ContinueOnError)
+	flags.IntVar(&passphraseFd, "passphrase-fd", -1, "read the passphrase from file descriptor `N`")
+	flags.StringVar(&passphraseFile, "passphrase-file", "", "read the passphrase from the first line of the file at `PATH`")
+	flags.StringVar(&passphraseCmd, "passphrase-cmd", "", "read the passphrase from the output of shell `COMMAND`")
+	flags.Usage = func() {
+		var names []string
+		for name := range commands {
+			names = append(names, name)
+		}
+		slices.Sort(names)
+		out := flags.Output()
+		fmt.Fprintln(out, "Usage: osafe [flags] [command] [args]")
+		fmt.Fprintln(out, "Edits the vault when no command is given.")
+		fmt.Fprintln(out, "Commands (run with -h for their flags):")
+		fmt.Fprintf(out, "  %s\n", strings.Join(names, ", "))
+		fmt.Fprintln(out, "Flags:")
+		flags.PrintDefaults()
+	}
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	args = flags.Args()
+	// Command
+	if len(args) == 0 {
+		return runEdit(nil)
+	}
+	if args[0] == clearClipCommand {
+		return runClearClip(args[1:]) // Internal, started by copy.
 	}
+	command, ok := commands[args[0]]
+	if !ok {
+		return fmt.Errorf("unknown command: %s", args[0])
+	}
+	return command(args[1:])
 }
 
-func run() error {
+func runEdit(args []string) error {
+	if len(args) > 0 {
+		return fmt.Errorf("unexpected arguments: %v", args)
+	}
 	// Read
 	m, err := storage.Read()
-	if err != nil {
+	var pendingErr *storage.PendingConflictError
+	var divergedErr *storage.DivergedError
+	if errors.As(err, &pendingErr) {
+		return mergePending(*pendingErr)
+	} else if errors.As(err, &divergedErr) {
+		return mergeDiverged(*divergedErr)
+	} else if err != nil {
 		return err
 	}
 	// Create or decrypt
@@ -43,24 +118,189 @@ func run() error {
 	if err != nil {
 		return err
 	}
-	// Edit
-	c, err := edit(dm.Content)
+	// Edit and write
+	return editAndWrite(dm, dm.Content, false)
+}
+
+// editAndWrite edits content and writes it, merging with changes written concurrently. Without force
+// nothing is written when the content is left unchanged.
+func editAndWrite(dm encryption.DecryptedMessage, content []byte, force bool) error {
+	for {
+		// Edit
+		c, err := edit(content)
+		if err != nil {
+			return err
+		}
+		if merge.HasConflictMarkers(c) && !merge.HasConflictMarkers(dm.Content) {
+			if bytes.Equal(c, content) {
+				return errors.New("conflicts left unresolved, nothing written")
+			}
+			fmt.Println("Conflict markers left, resolve them in the editor.")
+			content = c
+			continue
+		}
+		for {
+			if bytes.Equal(dm.Content, c) && !force {
+				return nil // No changes
+			}
+			// Write
+			edited, err := dm.WithContent(c)
+			if err != nil {
+				return err
+			}
+			err = storage.Write(edited.Message)
+			if !errors.Is(err, storage.ErrConflict) {
+				return err
+			}
+			// Merge with the version that was written concurrently
+			fmt.Println(err)
+			var clean bool
+			dm, c, clean, err = mergeLatest(dm, c)
+			if err != nil {
+				// Not losing the edit
+				id, backupErr := storage.SaveBackup(edited.Message)
+				if backupErr != nil {
+					return fmt.Errorf("%v, and failed saving the edit: %v", err, backupErr)
+				}
+				return fmt.Errorf("%v, the edit was saved, restore it with: osafe backups restore %s", err, id)
+			}
+			if !clean {
+				if readChoice("Conflicting changes, [m]erge in the editor or [r]eload (discarding your changes)? ", "m", "r") == "r" {
+					c = dm.Content // The latest version.
+				}
+				break
+			}
+			fmt.Println("Merged with changes from storage.")
+		}
+		content = c
+	}
+}
+
+// mergeLatest merges the edited content with the latest version in storage, using base as the common ancestor.
+func mergeLatest(base encryption.DecryptedMessage, edited []byte) (encryption.DecryptedMessage, []byte, bool, error) {
+	m, err := storage.Read()
 	if err != nil {
-		return err
+		return encryption.DecryptedMessage{}, nil, false, err
+	} else if m == nil {
+		return encryption.DecryptedMessage{}, nil, false, errors.New("vault was deleted from storage")
 	}
-	if bytes.Equal(dm.Content, c) {
-		return nil // No changes
+	latest, err := base.Decrypt(*m)
+	if err != nil {
+		return encryption.DecryptedMessage{}, nil, false, err
 	}
-	// Write
-	dm, err = dm.WithContent(c)
+	merged, clean := merge.Merge(base.Content, edited, latest.Content)
+	return latest, merged, clean, nil
+}
+
+// mergePending merges changes made offline with the changes made in storage since, and edits the result.
+func mergePending(e storage.PendingConflictError) error {
+	fmt.Println(e.Error())
+	latest, err := decrypt(e.Latest)
 	if err != nil {
 		return err
 	}
-	err = storage.Write(dm.Message)
+	local, err := latest.Decrypt(e.Pending.Local)
 	if err != nil {
 		return err
 	}
-	return nil
+	var base []byte
+	if e.Pending.Base != nil {
+		dm, err := latest.Decrypt(*e.Pending.Base)
+		if err != nil {
+			return err
+		}
+		base = dm.Content
+	}
+	merged, clean := merge.Merge(base, local.Content, latest.Content)
+	if clean {
+		fmt.Println("Merged offline changes with changes from storage, review them in the editor.")
+	} else {
+		fmt.Println("Conflicting changes, resolve them in the editor.")
+	}
+	if err := editAndWrite(latest, merged, false); err != nil {
+		return err
+	}
+	// Written, or the changes were dropped in the editor
+	return storage.DiscardPendingSync()
+}
+
+// mergeDiverged merges versions that were changed independently in different storages, and edits
+// the result. It's written even without changes, to replace the diverged versions.
+func mergeDiverged(e storage.DivergedError) error {
+	fmt.Println(e.Error())
+	newest, err := decrypt(e.Versions[0])
+	if err != nil {
+		return err
+	}
+	var base []byte
+	if e.Base != nil {
+		dm, err := newest.Decrypt(*e.Base)
+		if err != nil {
+			return err
+		}
+		base = dm.Content
+	}
+	merged, clean := newest.Content, true
+	for _, v := range e.Versions[1:] {
+		dm, err := newest.Decrypt(v)
+		if err != nil {
+			return err
+		}
+		if e.Base == nil {
+			// Unknown ancestor, keeping the lines of both
+			merged, clean = merge.Union(merged, dm.Content), false
+			continue
+		}
+		var c bool
+		merged, c = merge.Merge(base, merged, dm.Content)
+		clean = clean && c
+	}
+	if clean {
+		fmt.Println("Merged the diverged versions, review them in the editor.")
+	} else {
+		fmt.Println("Conflicting changes, resolve them in the editor.")
+	}
+	return editAndWrite(newest, merged, true)
+}
+
+// readDecrypted reads and decrypts the vault, for commands that don't open the editor.
+func readDecrypted() (encryption.DecryptedMessage, error) {
+	m, err := storage.Read()
+	if err != nil {
+		return encryption.DecryptedMessage{}, withMergeHint(err)
+	} else if m == nil {
+		return encryption.DecryptedMessage{}, errors.New("no vault yet, run `osafe` to create one")
+	}
+	return decrypt(*m)
+}
+
+// withMergeHint explains how to resolve errors that need merging in the editor.
+func withMergeHint(err error) error {
+	var pendingErr *storage.PendingConflictError
+	var divergedErr *storage.DivergedError
+	if errors.As(err, &pendingErr) || errors.As(err, &divergedErr) {
+		return fmt.Errorf("%v, run `osafe` to merge them", err)
+	}
+	return err
+}
+
+// promptsToStderr prints prompts and messages to stderr, keeping stdout for output that may be piped.
+// Returns the original stdout.
+func promptsToStderr() *os.File {
+	stdout := os.Stdout
+	os.Stdout = os.Stderr
+	return stdout
+}
+
+func readChoice(prompt string, choices ...string) string {
+	for {
+		fmt.Print(prompt)
+		var choice string
+		fmt.Scanln(&choice)
+		if slices.Contains(choices, strings.ToLower(choice)) {
+			return strings.ToLower(choice)
+		}
+	}
 }
 
 func create() (encryption.DecryptedMessage, error) {
@@ -68,10 +308,18 @@ func create() (encryption.DecryptedMessage, error) {
 	if err != nil {
 		return encryption.DecryptedMessage{}, err
 	}
-	return encryption.NewDecryptedMessage(passphrase)
+	dm, err := encryption.NewDecryptedMessage(passphrase)
+	if err != nil {
+		return encryption.DecryptedMessage{}, err
+	}
+	rememberInAgent(dm)
+	return dm, nil
 }
 
 func decrypt(m encryption.Message) (encryption.DecryptedMessage, error) {
+	if dm, ok := decryptWithAgent(m); ok {
+		return dm, nil
+	}
 	for {
 		passphrase, err := readPassphrase()
 		if err != nil {
@@ -79,18 +327,30 @@ func decrypt(m encryption.Message) (encryption.DecryptedMessage, error) {
 		}
 
 		dm, err := m.DecryptPassphrase(passphrase)
-		if err != nil {
+		if err != nil && !passphraseInteractive() {
+			return encryption.DecryptedMessage{}, err
+		} else if err != nil {
 			fmt.Println(err)
 			continue
 		}
 
+		rememberInAgent(dm)
 		return dm, nil
 	}
 }
 
-func readPassphrase() (passphrase []byte, err error) {
+func readPassphraseFromTerminal() (passphrase []byte, err error) {
 	fmt.Print("Enter passphrase: ")
 	fd := int(os.Stdin.Fd())
+	if !term.IsTerminal(fd) {
+		// Stdin is used for content, reading from the controlling terminal instead
+		tty, err := os.Open("/dev/tty")
+		if err != nil {
+			return nil, fmt.Errorf("failed opening terminal for passphrase: %v", err)
+		}
+		defer tty.Close()
+		fd = int(tty.Fd())
+	}
 
 	// Restore state after Ctrl+C
 	s, err := term.GetState(fd)
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+)
+
+var passphraseEnv = "OSAFE_PASSPHRASE"
+
+// Non-interactive passphrase sources, set by global flags.
+var passphraseFd = -1
+var passphraseFile string
+var passphraseCmd string
+
+// passphrase read from a non-interactive source, which may only be readable once.
+var passphrase []byte
+
+func readPassphrase() ([]byte, error) {
+	if passphraseInteractive() {
+		return readPassphraseFromTerminal()
+	}
+	if passphrase != nil {
+		return passphrase, nil
+	}
+	var err error
+	switch {
+	case passphraseFd != -1:
+		f := os.NewFile(uintptr(passphraseFd), "passphrase-fd")
+		if f == nil {
+			return nil, fmt.Errorf("invalid passphrase file descriptor: %d", passphraseFd)
+		}
+		defer f.Close()
+		passphrase, err = readPassphraseLine(f)
+	case passphraseFile != "":
+		f, openErr := os.Open(passphraseFile)
+		if openErr != nil {
+			return nil, fmt.Errorf("failed opening passphrase file: %v", openErr)
+		}
+		defer f.Close()
+		passphrase, err = readPassphraseLine(f)
+	case passphraseCmd != "":
+		passphrase, err = readPassphraseFromCmd()
+	default:
+		fmt.Fprintf(os.Stderr, "Warning: reading passphrase from %s, which may be visible to other processes.\n", passphraseEnv)
+		passphrase = []byte(os.Getenv(passphraseEnv))
+		os.Unsetenv(passphraseEnv) // Not passing it on to the editor.
+	}
+	if err != nil {
+		return nil, err
+	} else if len(passphrase) == 0 {
+		return nil, errors.New("empty passphrase")
+	}
+	return passphrase, nil
+}
+
+// passphraseInteractive returns whether the passphrase is read from the terminal, so a wrong one
+// can be entered again.
+func passphraseInteractive() bool {
+	_, env := os.LookupEnv(passphraseEnv)
+	return passphraseFd == -1 && passphraseFile == "" && passphraseCmd == "" && !env && passphrase == nil
+}
+
+func readPassphraseFromCmd() ([]byte, error) {
+	cmd := exec.Command("sh", "-c", passphraseCmd)
+	cmd.Stdin = os.Stdin
+	cmd.Stderr = os.Stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("failed running passphrase command: %v", err)
+	}
+	return readPassphraseLine(bytes.NewReader(out))
+}
+
+// readPassphraseLine reads the first line, without its line ending.
+func readPassphraseLine(r io.Reader) ([]byte, error) {
+	line, err := bufio.NewReader(r).ReadBytes('\n')
+	if err != nil && err != io.EOF {
+		return nil, fmt.Errorf("failed reading passphrase: %v", err)
+	}
+	line = bytes.TrimSuffix(line, []byte("\n"))
+	return bytes.TrimSuffix(line, []byte("\r")), nil
+}
@@ -0,0 +1,24 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"golang.org/x/term"
+)
+
+func runShow(args []string) error {
+	if len(args) > 0 {
+		return fmt.Errorf("unexpected arguments: %v", args)
+	}
+	stdout := promptsToStderr()
+	dm, err := readDecrypted()
+	if err != nil {
+		return err
+	}
+	if term.IsTerminal(int(stdout.Fd())) {
+		fmt.Fprintln(os.Stderr, "Warning: printing secrets to the terminal, they may stay in its scrollback.")
+	}
+	_, err = stdout.Write(dm.Content)
+	return err
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/odedniv/osafe/go/pkg/storage"
+)
+
+type statusJSON struct {
+	Storages    []storageStatusJSON `json:"storages"`
+	PendingSync *pendingSyncJSON    `json:"pendingSync"`
+}
+
+type storageStatusJSON struct {
+	Name         string     `json:"name"`
+	Exists       bool       `json:"exists"`
+	ModifiedTime *time.Time `json:"modifiedTime,omitempty"`
+	Size         int        `json:"size"`
+	Hash         string     `json:"hash,omitempty"`
+	Revision     int64      `json:"revision,omitempty"`
+	UpToDate     bool       `json:"upToDate"`
+	Error        string     `json:"error,omitempty"`
+}
+
+type pendingSyncJSON struct {
+	ModifiedTime time.Time `json:"modifiedTime"`
+}
+
+func runSync(args []string) error {
+	if len(args) > 0 {
+		return fmt.Errorf("unexpected arguments: %v", args)
+	}
+	if err := storage.Sync(); err != nil {
+		return withMergeHint(err)
+	}
+	fmt.Println("Synced storages.")
+	return nil
+}
+
+func runStatus(args []string) error {
+	flags := flag.NewFlagSet("status", flag.ContinueOnError)
+	asJSON := flags.Bool("json", false, "print as JSON")
+	if err := flags.Parse(args); err != nil {
+		return err
+	} else if flags.NArg() > 0 {
+		return fmt.Errorf("unexpected arguments: %v", flags.Args())
+	}
+
+	statuses, err := storage.Status()
+	if err != nil {
+		return err
+	}
+	pending, err := storage.ReadPendingSync()
+	if err != nil {
+		return err
+	}
+	if *asJSON {
+		return printStatusJSON(statuses, pending)
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "STORAGE\tMODIFIED\tSIZE\tHASH\tREVISION\tSTATUS")
+	for _, s := range statuses {
+		switch {
+		case s.Err != nil:
+			fmt.Fprintf(w, "%s\t-\t-\t-\t-\terror: %v\n", s.Name, s.Err)
+		case !s.Exists:
+			fmt.Fprintf(w, "%s\t-\t-\t-\t-\tmissing\n", s.Name)
+		default:
+			state := "up to date"
+			if !s.UpToDate {
+				state = "outdated"
+			}
+			revision := "none" // Written by the Android app, or an older version.
+			if s.Revision > 0 {
+				revision = fmt.Sprint(s.Revision)
+			}
+			fmt.Fprintf(w, "%s\t%s\t%d\t%s\t%s\t%s\n", s.Name, s.ModifiedTime.Local().Format(time.DateTime), s.Size, s.Hash[:12], revision, state)
+		}
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	if pending == nil {
+		fmt.Println("No pending syncs.")
+	} else {
+		fmt.Printf("Pending sync: offline changes from %s, run `osafe sync` with access to storages to sync them.\n", pending.ModifiedTime.Local().Format(time.DateTime))
+	}
+	return nil
+}
+
+func printStatusJSON(statuses []storage.StorageStatus, pending *storage.PendingSync) error {
+	out := statusJSON{Storages: []storageStatusJSON{}}
+	for _, s := range statuses {
+		j := storageStatusJSON{Name: s.Name, Exists: s.Exists, Size: s.Size, Hash: s.Hash, Revision: s.Revision, UpToDate: s.UpToDate}
+		if s.Exists {
+			j.ModifiedTime = &s.ModifiedTime
+		}
+		if s.Err != nil {
+			j.Error = s.Err.Error()
+		}
+		out.Storages = append(out.Storages, j)
+	}
+	if pending != nil {
+		out.PendingSync = &pendingSyncJSON{ModifiedTime: pending.ModifiedTime}
+	}
+	e := json.NewEncoder(os.Stdout)
+	e.SetIndent("", "  ")
+	return e.Encode(out)
+}
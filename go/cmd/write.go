@@ -0,0 +1,91 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/odedniv/osafe/go/pkg/encryption"
+	"github.com/odedniv/osafe/go/pkg/storage"
+)
+
+func runWrite(args []string) error {
+	if len(args) > 0 {
+		return fmt.Errorf("unexpected arguments: %v", args)
+	}
+	content, err := readStdin()
+	if err != nil {
+		return err
+	}
+	return updateContent(func([]byte) []byte { return content })
+}
+
+func runAppend(args []string) error {
+	if len(args) > 0 {
+		return fmt.Errorf("unexpected arguments: %v", args)
+	}
+	lines, err := readStdin()
+	if err != nil {
+		return err
+	} else if len(lines) == 0 {
+		return nil
+	}
+	return updateContent(func(content []byte) []byte {
+		return append(ensureNewline(content), ensureNewline(lines)...)
+	})
+}
+
+func readStdin() ([]byte, error) {
+	content, err := io.ReadAll(os.Stdin)
+	if err != nil {
+		return nil, fmt.Errorf("failed reading stdin: %v", err)
+	}
+	return content, nil
+}
+
+// updateContent writes the content returned by change, creating the vault if there's none. On
+// conflict the change is applied again over the latest version.
+func updateContent(change func(content []byte) []byte) error {
+	// Read
+	m, err := storage.Read()
+	if err != nil {
+		return withMergeHint(err)
+	}
+	// Create or decrypt
+	var dm encryption.DecryptedMessage
+	if m == nil {
+		dm, err = create()
+	} else {
+		dm, err = decrypt(*m)
+	}
+	if err != nil {
+		return err
+	}
+	for {
+		c := change(dm.Content)
+		if m != nil && bytes.Equal(dm.Content, c) {
+			return nil // No changes
+		}
+		// Write
+		edited, err := dm.WithContent(c)
+		if err != nil {
+			return err
+		}
+		err = storage.Write(edited.Message)
+		if !errors.Is(err, storage.ErrConflict) {
+			return err
+		}
+		// Applying again over the version that was written concurrently
+		fmt.Println(err)
+		if m, err = storage.Read(); err != nil {
+			return withMergeHint(err)
+		} else if m == nil {
+			return errors.New("vault was deleted from storage")
+		}
+		if dm, err = dm.Decrypt(*m); err != nil {
+			return err
+		}
+	}
+}
@@ -0,0 +1,114 @@
+// Package agent keeps the vault's base key in a background process, like ssh-agent, so the
+// passphrase isn't needed on every command.
+//
+// The protocol is a single line request and a single line response over a Unix socket:
+//
+//	GET          -> OK <base64 key> | LOCKED
+//	SET <base64> -> OK
+//	LOCK         -> OK
+//
+// Errors are responded with ERR <message>.
+package agent
+
+import (
+	"bufio"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path"
+	"strings"
+	"syscall"
+	"time"
+)
+
+var socketPath = path.Join(".osafe", "agent.sock") // Relative to os.UserHomeDir.
+var socketEnv = "OSAFE_AGENT_SOCK"
+var dialTimeout = time.Second
+
+// ErrNotRunning is returned when there's no agent listening on the socket.
+var ErrNotRunning = errors.New("osafe agent is not running")
+
+// Get returns the key kept by the agent, or nil when it's locked.
+func Get() ([]byte, error) {
+	resp, err := request("GET")
+	if err != nil {
+		return nil, err
+	}
+	if resp == "LOCKED" {
+		return nil, nil
+	}
+	encoded, ok := strings.CutPrefix(resp, "OK ")
+	if !ok {
+		return nil, fmt.Errorf("unexpected agent response: %s", resp)
+	}
+	key, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, fmt.Errorf("failed decoding key from agent: %v", err)
+	}
+	return key, nil
+}
+
+// Set makes the agent keep key until it's idle for its timeout.
+func Set(key []byte) error {
+	resp, err := request("SET " + base64.StdEncoding.EncodeToString(key))
+	if err != nil {
+		return err
+	} else if resp != "OK" {
+		return fmt.Errorf("unexpected agent response: %s", resp)
+	}
+	return nil
+}
+
+// Lock makes the agent wipe its key.
+func Lock() error {
+	resp, err := request("LOCK")
+	if err != nil {
+		return err
+	} else if resp != "OK" {
+		return fmt.Errorf("unexpected agent response: %s", resp)
+	}
+	return nil
+}
+
+// SocketName returns the path of the agent's socket, from OSAFE_AGENT_SOCK or in ~/.osafe.
+func SocketName() (string, error) {
+	if name := os.Getenv(socketEnv); name != "" {
+		return name, nil
+	}
+	homeDir, err := os.UserHomeDir()
+	if err != nil {
+		return "", fmt.Errorf("failed getting user home dir: %v", err)
+	}
+	return path.Join(homeDir, socketPath), nil
+}
+
+func request(req string) (string, error) {
+	name, err := SocketName()
+	if err != nil {
+		return "", err
+	}
+	conn, err := net.DialTimeout("unix", name, dialTimeout)
+	if errors.Is(err, os.ErrNotExist) || errors.Is(err, syscall.ECONNREFUSED) {
+		return "", ErrNotRunning
+	} else if err != nil {
+		return "", fmt.Errorf("failed connecting to agent: %v", err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(dialTimeout))
+	// Requesting
+	if _, err := fmt.Fprintln(conn, req); err != nil {
+		return "", fmt.Errorf("failed sending request to agent: %v", err)
+	}
+	// Responding
+	resp, err := bufio.NewReader(conn).ReadString('\n')
+	if err != nil {
+		return "", fmt.Errorf("failed reading response from agent: %v", err)
+	}
+	resp = strings.TrimSuffix(resp, "\n")
+	if message, ok := strings.CutPrefix(resp, "ERR "); ok {
+		return "", fmt.Errorf("agent failed: %s", message)
+	}
+	return resp, nil
+}
@@ -0,0 +1,160 @@
+package agent
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"os/signal"
+	"path"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// server keeps the key in memory outside the Go heap, locked so it's never swapped, until it's
+// idle for the timeout or locked.
+type server struct {
+	timeout time.Duration
+
+	mu     sync.Mutex
+	memory []byte // Locked, holding the key when size > 0.
+	size   int
+	timer  *time.Timer
+}
+
+// Serve runs the agent until it's interrupted or terminated. A zero timeout keeps the key until
+// locked.
+func Serve(timeout time.Duration) error {
+	name, err := SocketName()
+	if err != nil {
+		return err
+	}
+	if _, err := request("GET"); !errors.Is(err, ErrNotRunning) {
+		return fmt.Errorf("osafe agent is already running on %s", name)
+	}
+	// Locking memory
+	s := &server{timeout: timeout}
+	s.memory, err = syscall.Mmap(-1, 0, os.Getpagesize(), syscall.PROT_READ|syscall.PROT_WRITE, syscall.MAP_ANON|syscall.MAP_PRIVATE)
+	if err != nil {
+		return fmt.Errorf("failed allocating agent memory: %v", err)
+	}
+	defer syscall.Munmap(s.memory)
+	if err := syscall.Mlock(s.memory); err != nil {
+		return fmt.Errorf("failed locking agent memory: %v", err)
+	}
+	defer func() {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		s.wipe()
+	}()
+	// Listening, only the user can connect
+	if err := os.MkdirAll(path.Dir(name), 0700); err != nil {
+		return fmt.Errorf("failed creating agent socket dir: %v", err)
+	}
+	os.Remove(name) // Left by an agent that was killed.
+	oldUmask := syscall.Umask(0077)
+	l, err := net.Listen("unix", name)
+	syscall.Umask(oldUmask)
+	if err != nil {
+		return fmt.Errorf("failed listening on agent socket: %v", err)
+	}
+	defer l.Close()
+	// Stopping on signals, closing the listener removes the socket
+	c := make(chan os.Signal, 1)
+	signal.Notify(c, os.Interrupt, syscall.SIGTERM, syscall.SIGHUP)
+	defer signal.Stop(c)
+	go func() {
+		<-c
+		l.Close()
+	}()
+
+	for {
+		conn, err := l.Accept()
+		if errors.Is(err, net.ErrClosed) {
+			return nil
+		} else if err != nil {
+			return fmt.Errorf("failed accepting agent connection: %v", err)
+		}
+		go s.handle(conn)
+	}
+}
+
+func (s *server) handle(conn net.Conn) {
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(dialTimeout))
+	r := bufio.NewReaderSize(conn, len("SET \n")+base64.StdEncoding.EncodedLen(len(s.memory)))
+	req, err := r.ReadSlice('\n')
+	defer clear(req) // May hold a key.
+	if err != nil {
+		fmt.Fprintf(conn, "ERR failed reading request: %v\n", err)
+		return
+	}
+	req = bytes.TrimSuffix(req, []byte("\n"))
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	switch {
+	case bytes.Equal(req, []byte("GET")):
+		if s.size == 0 {
+			fmt.Fprintln(conn, "LOCKED")
+			return
+		}
+		resp := make([]byte, 3+base64.StdEncoding.EncodedLen(s.size)+1)
+		defer clear(resp)
+		copy(resp, "OK ")
+		base64.StdEncoding.Encode(resp[3:], s.memory[:s.size])
+		resp[len(resp)-1] = '\n'
+		conn.Write(resp)
+		s.touch()
+	case bytes.HasPrefix(req, []byte("SET ")):
+		encoded := req[len("SET "):]
+		if base64.StdEncoding.DecodedLen(len(encoded)) > len(s.memory) {
+			fmt.Fprintln(conn, "ERR key too large")
+			return
+		}
+		s.wipe()
+		n, err := base64.StdEncoding.Decode(s.memory, encoded)
+		if err != nil {
+			s.wipe()
+			fmt.Fprintf(conn, "ERR failed decoding key: %v\n", err)
+			return
+		}
+		s.size = n
+		s.touch()
+		fmt.Fprintln(conn, "OK")
+	case bytes.Equal(req, []byte("LOCK")):
+		s.wipe()
+		fmt.Fprintln(conn, "OK")
+	default:
+		fmt.Fprintln(conn, "ERR unknown request")
+	}
+}
+
+// touch restarts the idle timeout, must be called with mu held.
+func (s *server) touch() {
+	if s.timeout == 0 {
+		return
+	}
+	if s.timer != nil {
+		s.timer.Stop()
+	}
+	s.timer = time.AfterFunc(s.timeout, func() {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		s.wipe()
+	})
+}
+
+// wipe forgets the key, must be called with mu held.
+func (s *server) wipe() {
+	clear(s.memory)
+	s.size = 0
+	if s.timer != nil {
+		s.timer.Stop()
+		s.timer = nil
+	}
+}
@@ -0,0 +1,127 @@
+package agent
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// startServer serves on a socket in a new directory until the test ends.
+func startServer(t *testing.T, timeout time.Duration) string {
+	name := path.Join(t.TempDir(), "agent", "agent.sock")
+	t.Setenv(socketEnv, name)
+	done := make(chan error, 1)
+	go func() { done <- Serve(timeout) }()
+	// Waiting for it to accept, which is after it handles signals
+	for deadline := time.Now().Add(time.Second * 5); ; {
+		select {
+		case err := <-done:
+			t.Fatalf("Serve() error = %v", err)
+		default:
+		}
+		if _, err := Get(); !errors.Is(err, ErrNotRunning) {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("Serve() didn't start")
+		}
+		time.Sleep(time.Millisecond * 10)
+	}
+	t.Cleanup(func() {
+		syscall.Kill(os.Getpid(), syscall.SIGTERM)
+		if err := <-done; err != nil {
+			t.Errorf("Serve() error = %v", err)
+		}
+		if _, err := os.Stat(name); !errors.Is(err, os.ErrNotExist) {
+			t.Errorf("socket left after stopping: %v", err)
+		}
+	})
+	return name
+}
+
+func TestServer(t *testing.T) {
+	startServer(t, 0)
+	key := []byte("base-key")
+
+	if got, err := Get(); err != nil || got != nil {
+		t.Fatalf("Get() = %q, %v, want locked", got, err)
+	}
+	if err := Set(key); err != nil {
+		t.Fatalf("Set() error = %v", err)
+	}
+	if got, err := Get(); err != nil || !bytes.Equal(got, key) {
+		t.Fatalf("Get() = %q, %v, want %q", got, err, key)
+	}
+	if err := Lock(); err != nil {
+		t.Fatalf("Lock() error = %v", err)
+	}
+	if got, err := Get(); err != nil || got != nil {
+		t.Errorf("Get() after Lock() = %q, %v, want locked", got, err)
+	}
+	if _, err := request("UNLOCK"); err == nil {
+		t.Error("request() unknown error = nil, want an error")
+	}
+}
+
+func TestServerAlreadyRunning(t *testing.T) {
+	startServer(t, 0)
+	if err := Serve(0); err == nil {
+		t.Error("Serve() error = nil, want already running")
+	}
+}
+
+func TestServerIdleTimeout(t *testing.T) {
+	startServer(t, time.Millisecond*500)
+	key := []byte("base-key")
+	if err := Set(key); err != nil {
+		t.Fatalf("Set() error = %v", err)
+	}
+	// Getting restarts the timeout
+	time.Sleep(time.Millisecond * 300)
+	if got, err := Get(); err != nil || !bytes.Equal(got, key) {
+		t.Fatalf("Get() = %q, %v, want %q", got, err, key)
+	}
+	time.Sleep(time.Millisecond * 300)
+	if got, err := Get(); err != nil || !bytes.Equal(got, key) {
+		t.Fatalf("Get() = %q, %v, want %q", got, err, key)
+	}
+	// Idle
+	time.Sleep(time.Millisecond * 800)
+	if got, err := Get(); err != nil || got != nil {
+		t.Errorf("Get() when idle = %q, %v, want locked", got, err)
+	}
+}
+
+func TestServerOversizedKey(t *testing.T) {
+	startServer(t, 0)
+	key := []byte("base-key")
+	if err := Set(key); err != nil {
+		t.Fatalf("Set() error = %v", err)
+	}
+	for _, size := range []int{os.Getpagesize() + 1, os.Getpagesize() * 2} {
+		if err := Set(make([]byte, size)); err == nil {
+			t.Errorf("Set() with %d bytes error = nil, want an error", size)
+		}
+	}
+	// Keeping the key
+	if got, err := Get(); err != nil || !bytes.Equal(got, key) {
+		t.Errorf("Get() = %q, %v, want %q", got, err, key)
+	}
+}
+
+func TestServerSocketPermissions(t *testing.T) {
+	name := startServer(t, 0)
+	for _, n := range []string{path.Dir(name), name} {
+		info, err := os.Stat(n)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if perm := info.Mode().Perm(); perm&0077 != 0 {
+			t.Errorf("%s mode = %v, want only the user", n, perm)
+		}
+	}
+}
@@ -0,0 +1,125 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path"
+)
+
+var configFilePath = path.Join(".osafe", "config.json") // Relative to os.UserHomeDir.
+
+type Config struct {
+	Drive  *Drive  `json:"drive,omitempty"`
+	WebDAV *WebDAV `json:"webdav,omitempty"`
+	Git    *Git    `json:"git,omitempty"`
+	SFTP   *SFTP   `json:"sftp,omitempty"`
+	// Backups of the encrypted vault, kept locally before every write.
+	Backups Backups `json:"backups"`
+}
+
+// Drive enables the Google Drive storage.
+type Drive struct {
+	// Auth selects how to authorize Drive access:
+	//   - "" (default) opens a local browser, or falls back to "paste" when there's none.
+	//   - "paste" prints a link to open on any browser, and reads back the address it redirects to.
+	//   - "device" prints a code to enter on any device, requires an OAuthClientFile of type
+	//     "TVs and Limited Input devices".
+	Auth string `json:"auth,omitempty"`
+	// OAuthClientFile replaces the built-in Google OAuth client, relative to the user home dir unless absolute.
+	OAuthClientFile string `json:"oauthClientFile,omitempty"`
+	// FolderId of the folder holding the vault file, defaults to the root of My Drive.
+	FolderId string `json:"folderId,omitempty"`
+	// FolderPath of the folder holding the vault file, relative to FolderId (or AppData), e.g. "Secrets/OSafe".
+	// Only folders created by OSafe are visible to it, so missing folders are created on write.
+	FolderPath string `json:"folderPath,omitempty"`
+	// Filename of the vault file, defaults to osafe.json.
+	Filename string `json:"filename,omitempty"`
+	// AppData keeps the vault file in the hidden application data folder instead of My Drive.
+	// Authorizations from before this option existed need `osafe auth login` to use it.
+	AppData bool `json:"appData,omitempty"`
+}
+
+const (
+	DriveAuthBrowser = ""
+	DriveAuthPaste   = "paste"
+	DriveAuthDevice  = "device"
+)
+
+// WebDAV enables a WebDAV storage (e.g. Nextcloud).
+type WebDAV struct {
+	// URL of the vault file, e.g. https://cloud.example.com/remote.php/dav/files/user/osafe.json
+	URL      string `json:"url"`
+	Username string `json:"username"`
+	// Password, or an app password where the server supports them.
+	Password string `json:"password"`
+}
+
+// Git enables a git working copy storage, committing on every write.
+type Git struct {
+	// Path of an existing working copy, relative to the user home dir unless absolute.
+	Dir string `json:"dir"`
+	// Remote to pull from and push to, empty to keep history local only.
+	Remote string `json:"remote,omitempty"`
+	// Branch on the remote, defaults to the current branch.
+	Branch string `json:"branch,omitempty"`
+}
+
+// SFTP enables an SFTP storage, authenticating with the SSH agent or key files.
+type SFTP struct {
+	// Host with an optional port, e.g. bastion.example.com:22
+	Host string `json:"host"`
+	User string `json:"user"`
+	// Path of the vault file on the remote, relative to the login directory unless absolute.
+	Path string `json:"path"`
+	// Private key files, relative to the user home dir unless absolute. Defaults to the standard ~/.ssh ones.
+	IdentityFiles []string `json:"identityFiles,omitempty"`
+	// Known hosts file, relative to the user home dir unless absolute. Defaults to ~/.ssh/known_hosts.
+	KnownHostsFile string `json:"knownHostsFile,omitempty"`
+}
+
+// Backups configures the retention of local backups, a backup is deleted when either limit is exceeded.
+type Backups struct {
+	// Count of backups to keep, defaults to 100.
+	Count int `json:"count,omitempty"`
+	// MaxAgeDays to keep backups for, defaults to 365. The newest backup is always kept.
+	MaxAgeDays int `json:"maxAgeDays,omitempty"`
+}
+
+// Default is used when there's no config file, keeping the behavior from before configs existed. Drive
+// is also the storage of config files that don't configure any.
+var Default = Config{Drive: &Drive{}}
+
+func Load() (Config, error) {
+	name, err := fileName()
+	if err != nil {
+		return Config{}, fmt.Errorf("failed getting config file name: %v", err)
+	}
+
+	f, err := os.Open(name)
+	if os.IsNotExist(err) {
+		return Default, nil
+	} else if err != nil {
+		return Config{}, fmt.Errorf("failed opening config file: %v", err)
+	}
+	defer f.Close()
+
+	var c Config
+	err = json.NewDecoder(f).Decode(&c)
+	if err != nil {
+		return Config{}, fmt.Errorf("failed parsing config file: %v", err)
+	}
+	// Keeping the default storage when only other settings are configured, e.g. backups
+	if c.Drive == nil && c.WebDAV == nil && c.Git == nil && c.SFTP == nil {
+		c.Drive = &Drive{}
+	}
+	return c, nil
+}
+
+func fileName() (string, error) {
+	homeDir, err := os.UserHomeDir()
+	if err != nil {
+		return "", fmt.Errorf("failed getting user home dir: %v", err)
+	}
+	return path.Join(homeDir, configFilePath), nil
+}
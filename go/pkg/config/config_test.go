@@ -0,0 +1,48 @@
+package config
+
+import (
+	"os"
+	"path"
+	"testing"
+)
+
+func TestLoad(t *testing.T) {
+	tests := []struct {
+		name      string
+		file      string // No config file when empty.
+		wantDrive bool
+		wantCount int // Of backups.
+		wantErr   bool
+	}{
+		{name: "no file", wantDrive: true},
+		{name: "empty", file: "{}", wantDrive: true},
+		{name: "only backups", file: `{"backups": {"count": 5}}`, wantDrive: true, wantCount: 5},
+		{name: "other storage", file: `{"webdav": {"url": "https://example.com/osafe.json"}}`},
+		{name: "drive and other storage", file: `{"drive": {"appData": true}, "git": {"dir": "vault"}}`, wantDrive: true},
+		{name: "invalid", file: `{"drive": `, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			home := t.TempDir()
+			t.Setenv("HOME", home)
+			if tt.file != "" {
+				if err := os.MkdirAll(path.Join(home, path.Dir(configFilePath)), 0700); err != nil {
+					t.Fatal(err)
+				}
+				if err := os.WriteFile(path.Join(home, configFilePath), []byte(tt.file), 0600); err != nil {
+					t.Fatal(err)
+				}
+			}
+			c, err := Load()
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("Load() = %+v, want an error", c)
+				}
+				return
+			}
+			if err != nil || (c.Drive != nil) != tt.wantDrive || c.Backups.Count != tt.wantCount {
+				t.Errorf("Load() = %+v, %v, want drive: %v, backups count: %d", c, err, tt.wantDrive, tt.wantCount)
+			}
+		})
+	}
+}
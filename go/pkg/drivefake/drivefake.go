@@ -0,0 +1,447 @@
+// Package drivefake is an in-memory fake of the Google Drive v3 endpoints used by the Drive storage:
+// files list, get, create and update, and revisions list and get. Point a drive.Service at it with option.WithEndpoint(s.Endpoint())
+// and option.WithHTTPClient(s.Client()).
+package drivefake
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"google.golang.org/api/drive/v3"
+)
+
+var queryEscapeRegexp = regexp.MustCompile(`\\(.)`)
+var queryClauseRegexp = regexp.MustCompile(`^(?:(\w+) = '((?:[^'\\]|\\.)*)'|'((?:[^'\\]|\\.)*)' in parents|trashed = (true|false))$`)
+
+// File is a file kept by the fake, root folders are "root" and "appDataFolder".
+type File struct {
+	Id           string
+	Name         string
+	MimeType     string
+	Parents      []string
+	ModifiedTime time.Time
+	Version      int64
+	Trashed      bool
+	Content      []byte
+	Revisions    []Revision // Oldest first, added when the content is created or updated.
+}
+
+// Revision is a past version of a file's content.
+type Revision struct {
+	Id           string
+	ModifiedTime time.Time
+	Content      []byte
+}
+
+// Server is a running fake, embedding the httptest.Server it's served by.
+type Server struct {
+	*httptest.Server
+
+	mu       sync.Mutex
+	files    map[string]*File
+	nextId   int
+	failures []int
+	requests int
+}
+
+// NewServer starts a fake with no files, call Close when done.
+func NewServer() *Server {
+	s := &Server{files: map[string]*File{}}
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /drive/v3/files", s.handleList)
+	mux.HandleFunc("GET /drive/v3/files/{fileId}", s.handleGet)
+	mux.HandleFunc("POST /drive/v3/files", s.handleCreate)
+	mux.HandleFunc("POST /upload/drive/v3/files", s.handleCreate)
+	mux.HandleFunc("PATCH /drive/v3/files/{fileId}", s.handleUpdate)
+	mux.HandleFunc("PATCH /upload/drive/v3/files/{fileId}", s.handleUpdate)
+	mux.HandleFunc("GET /drive/v3/files/{fileId}/revisions", s.handleListRevisions)
+	mux.HandleFunc("GET /drive/v3/files/{fileId}/revisions/{revisionId}", s.handleGetRevision)
+	s.Server = httptest.NewServer(s.failing(mux))
+	return s
+}
+
+// Endpoint is the base URL to use instead of the Drive API.
+func (s *Server) Endpoint() string {
+	return s.URL + "/drive/v3/"
+}
+
+// Put adds or replaces a file, generating an ID and version when they're empty, and a revision of
+// its content when it has none.
+func (s *Server) Put(f File) File {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if f.Id == "" {
+		f.Id = s.newId()
+	}
+	if f.Version == 0 {
+		f.Version = 1
+	}
+	if f.Revisions == nil && f.Content != nil {
+		f.addRevision()
+	}
+	s.files[f.Id] = &f
+	return f
+}
+
+// Files returns copies of all files, including trashed ones, newest first.
+func (s *Server) Files() []File {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var files []File
+	for _, f := range s.files {
+		files = append(files, *f)
+	}
+	slices.SortFunc(files, func(a, b File) int { return b.ModifiedTime.Compare(a.ModifiedTime) })
+	return files
+}
+
+// Fail makes the next requests fail with the given statuses, one per request.
+func (s *Server) Fail(statuses ...int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.failures = append(s.failures, statuses...)
+}
+
+// Requests returns how many requests were received, including failed ones.
+func (s *Server) Requests() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.requests
+}
+
+func (s *Server) failing(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.mu.Lock()
+		s.requests++
+		var status int
+		if len(s.failures) > 0 {
+			status, s.failures = s.failures[0], s.failures[1:]
+		}
+		s.mu.Unlock()
+		if status != 0 {
+			writeError(w, status, http.StatusText(status))
+			return
+		}
+		h.ServeHTTP(w, r)
+	})
+}
+
+func (s *Server) handleList(w http.ResponseWriter, r *http.Request) {
+	match, err := parseQuery(r.URL.Query().Get("q"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	spaces := strings.Split(r.URL.Query().Get("spaces"), ",")
+	if r.URL.Query().Get("spaces") == "" {
+		spaces = []string{"drive"}
+	}
+	orderBy := r.URL.Query().Get("orderBy")
+	if orderBy != "" && orderBy != "modifiedTime desc" {
+		writeError(w, http.StatusBadRequest, "unsupported orderBy: "+orderBy)
+		return
+	}
+
+	s.mu.Lock()
+	var files []*File
+	for _, f := range s.files {
+		if match(f) && slices.Contains(spaces, s.space(f)) {
+			files = append(files, f)
+		}
+	}
+	slices.SortFunc(files, func(a, b *File) int { return b.ModifiedTime.Compare(a.ModifiedTime) })
+	var list drive.FileList
+	for _, f := range files {
+		list.Files = append(list.Files, apiFile(f))
+	}
+	s.mu.Unlock()
+	writeJSON(w, &list)
+}
+
+func (s *Server) handleGet(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	f, ok := s.files[r.PathValue("fileId")]
+	if !ok {
+		writeError(w, http.StatusNotFound, "File not found: "+r.PathValue("fileId"))
+		return
+	}
+	if r.URL.Query().Get("alt") == "media" {
+		w.Header().Set("Content-Type", "application/octet-stream")
+		w.Write(f.Content)
+		return
+	}
+	writeJSON(w, apiFile(f))
+}
+
+func (s *Server) handleCreate(w http.ResponseWriter, r *http.Request) {
+	metadata, content, err := readUpload(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	f := &File{Id: s.newId(), Name: metadata.Name, MimeType: metadata.MimeType, Parents: metadata.Parents, Version: 1, Content: content}
+	if len(f.Parents) == 0 {
+		f.Parents = []string{"root"}
+	}
+	for _, parent := range f.Parents {
+		if !s.folder(parent) {
+			writeError(w, http.StatusNotFound, "File not found: "+parent)
+			return
+		}
+	}
+	if f.ModifiedTime, err = modifiedTime(metadata); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if content != nil {
+		f.addRevision()
+	}
+	s.files[f.Id] = f
+	writeJSON(w, apiFile(f))
+}
+
+func (s *Server) handleUpdate(w http.ResponseWriter, r *http.Request) {
+	metadata, content, err := readUpload(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	f, ok := s.files[r.PathValue("fileId")]
+	if !ok {
+		writeError(w, http.StatusNotFound, "File not found: "+r.PathValue("fileId"))
+		return
+	}
+	if metadata.Name != "" {
+		f.Name = metadata.Name
+	}
+	if metadata.Trashed {
+		f.Trashed = true
+	}
+	if content != nil || metadata.ModifiedTime != "" {
+		if f.ModifiedTime, err = modifiedTime(metadata); err != nil {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+	}
+	if content != nil {
+		f.Content = content
+		f.addRevision()
+	}
+	f.Version++
+	writeJSON(w, apiFile(f))
+}
+
+func (s *Server) handleListRevisions(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	f, ok := s.files[r.PathValue("fileId")]
+	if !ok {
+		writeError(w, http.StatusNotFound, "File not found: "+r.PathValue("fileId"))
+		return
+	}
+	list := drive.RevisionList{Revisions: []*drive.Revision{}}
+	for _, revision := range f.Revisions {
+		list.Revisions = append(list.Revisions, &drive.Revision{
+			Id:           revision.Id,
+			ModifiedTime: revision.ModifiedTime.UTC().Format(time.RFC3339Nano),
+			Size:         int64(len(revision.Content)),
+		})
+	}
+	writeJSON(w, &list)
+}
+
+func (s *Server) handleGetRevision(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	f, ok := s.files[r.PathValue("fileId")]
+	if !ok {
+		writeError(w, http.StatusNotFound, "File not found: "+r.PathValue("fileId"))
+		return
+	}
+	i := slices.IndexFunc(f.Revisions, func(revision Revision) bool { return revision.Id == r.PathValue("revisionId") })
+	if i == -1 {
+		writeError(w, http.StatusNotFound, "Revision not found: "+r.PathValue("revisionId"))
+		return
+	}
+	if r.URL.Query().Get("alt") != "media" {
+		writeError(w, http.StatusBadRequest, "only media downloads of revisions are supported")
+		return
+	}
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Write(f.Revisions[i].Content)
+}
+
+func (s *Server) newId() string {
+	s.nextId++
+	return fmt.Sprintf("fake-%d", s.nextId)
+}
+
+func (f *File) addRevision() {
+	f.Revisions = append(f.Revisions, Revision{
+		Id:           fmt.Sprintf("%s-r%d", f.Id, len(f.Revisions)+1),
+		ModifiedTime: f.ModifiedTime,
+		Content:      f.Content,
+	})
+}
+
+// folder returns whether id is a root or a folder.
+func (s *Server) folder(id string) bool {
+	if id == "root" || id == "appDataFolder" {
+		return true
+	}
+	f, ok := s.files[id]
+	return ok && f.MimeType == "application/vnd.google-apps.folder"
+}
+
+// space returns the space the file is in, by its ancestors.
+func (s *Server) space(f *File) string {
+	for len(f.Parents) > 0 {
+		switch f.Parents[0] {
+		case "root":
+			return "drive"
+		case "appDataFolder":
+			return "appDataFolder"
+		}
+		parent, ok := s.files[f.Parents[0]]
+		if !ok {
+			break
+		}
+		f = parent
+	}
+	return "drive"
+}
+
+// parseQuery supports the subset of the Drive query language the storage uses: clauses joined by
+// "and" comparing the name or mimeType, checking a parent or whether the file is trashed.
+func parseQuery(q string) (func(f *File) bool, error) {
+	var matchers []func(f *File) bool
+	for _, clause := range strings.Split(q, " and ") {
+		if clause == "" {
+			continue
+		}
+		m := queryClauseRegexp.FindStringSubmatch(clause)
+		switch {
+		case m == nil:
+			return nil, fmt.Errorf("unsupported query clause: %s", clause)
+		case m[1] == "name":
+			name := unescape(m[2])
+			matchers = append(matchers, func(f *File) bool { return f.Name == name })
+		case m[1] == "mimeType":
+			mimeType := unescape(m[2])
+			matchers = append(matchers, func(f *File) bool { return f.MimeType == mimeType })
+		case m[1] != "":
+			return nil, fmt.Errorf("unsupported query field: %s", m[1])
+		case m[3] != "":
+			parent := unescape(m[3])
+			matchers = append(matchers, func(f *File) bool { return slices.Contains(f.Parents, parent) })
+		default:
+			trashed := m[4] == "true"
+			matchers = append(matchers, func(f *File) bool { return f.Trashed == trashed })
+		}
+	}
+	return func(f *File) bool {
+		for _, match := range matchers {
+			if !match(f) {
+				return false
+			}
+		}
+		return true
+	}, nil
+}
+
+func unescape(s string) string {
+	return queryEscapeRegexp.ReplaceAllString(s, "$1")
+}
+
+// readUpload reads the file metadata, and the content for media uploads.
+func readUpload(r *http.Request) (drive.File, []byte, error) {
+	var metadata drive.File
+	mediaType, params, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if err != nil {
+		return drive.File{}, nil, fmt.Errorf("invalid content type: %v", err)
+	}
+	switch r.URL.Query().Get("uploadType") {
+	case "":
+		err := json.NewDecoder(r.Body).Decode(&metadata)
+		return metadata, nil, err
+	case "media":
+		content, err := io.ReadAll(r.Body)
+		return metadata, content, err
+	case "multipart":
+		if mediaType != "multipart/related" {
+			return drive.File{}, nil, fmt.Errorf("unexpected multipart content type: %s", mediaType)
+		}
+		mr := multipart.NewReader(r.Body, params["boundary"])
+		part, err := mr.NextPart()
+		if err != nil {
+			return drive.File{}, nil, fmt.Errorf("failed reading metadata part: %v", err)
+		}
+		if err := json.NewDecoder(part).Decode(&metadata); err != nil {
+			return drive.File{}, nil, fmt.Errorf("failed decoding metadata part: %v", err)
+		}
+		part, err = mr.NextPart()
+		if err != nil {
+			return drive.File{}, nil, fmt.Errorf("failed reading media part: %v", err)
+		}
+		content, err := io.ReadAll(part)
+		return metadata, content, err
+	default:
+		return drive.File{}, nil, fmt.Errorf("unsupported upload type: %s", r.URL.Query().Get("uploadType"))
+	}
+}
+
+// modifiedTime returns the modified time set in metadata, or now when it's not set.
+func modifiedTime(metadata drive.File) (time.Time, error) {
+	if metadata.ModifiedTime == "" {
+		return time.Now(), nil
+	}
+	t, err := time.Parse(time.RFC3339, metadata.ModifiedTime)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid modifiedTime: %v", err)
+	}
+	return t, nil
+}
+
+func apiFile(f *File) *drive.File {
+	return &drive.File{
+		Id:           f.Id,
+		Name:         f.Name,
+		MimeType:     f.MimeType,
+		Parents:      f.Parents,
+		ModifiedTime: f.ModifiedTime.UTC().Format(time.RFC3339Nano),
+		Version:      f.Version,
+		Trashed:      f.Trashed,
+		Size:         int64(len(f.Content)),
+	}
+}
+
+func writeJSON(w http.ResponseWriter, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(v)
+}
+
+// writeError responds like the Google APIs do, which googleapi.CheckResponse parses.
+func writeError(w http.ResponseWriter, status int, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]any{
+		"error": map[string]any{"code": status, "message": message},
+	})
+}
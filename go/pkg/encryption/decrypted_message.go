@@ -29,7 +29,7 @@ func NewDecryptedMessage(passphrase []byte) (DecryptedMessage, error) {
 		return DecryptedMessage{}, fmt.Errorf("failed digesting key: %v", err)
 	}
 
-	kc, err := EncryptContent(baseKey, kv)
+	kc, err := EncryptContent(kv, baseKey)
 	if err != nil {
 		return DecryptedMessage{}, fmt.Errorf("failed encrypting key: %v", err)
 	}
@@ -55,3 +55,23 @@ func (dm *DecryptedMessage) WithContent(content []byte) (DecryptedMessage, error
 		Content: content,
 	}, nil
 }
+
+// Decrypt decrypts another version of the message, e.g. one that was written concurrently,
+// using the same base key.
+func (dm *DecryptedMessage) Decrypt(m Message) (DecryptedMessage, error) {
+	c, err := m.Content.Decrypt(dm.baseKey)
+	if err != nil {
+		return DecryptedMessage{}, fmt.Errorf("failed decrypting content: %v", err)
+	}
+	return DecryptedMessage{
+		Message: m,
+		baseKey: dm.baseKey,
+		Content: c,
+	}, nil
+}
+
+// BaseKey returns the key the content is encrypted with, e.g. to keep it in an agent. Callers
+// must not modify it.
+func (dm *DecryptedMessage) BaseKey() []byte {
+	return dm.baseKey
+}
@@ -0,0 +1,53 @@
+package encryption
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+)
+
+func TestNewDecryptedMessageRoundTrip(t *testing.T) {
+	dm, err := NewDecryptedMessage([]byte("correct horse"))
+	if err != nil {
+		t.Fatalf("NewDecryptedMessage() error = %v", err)
+	}
+	dm, err = dm.WithContent([]byte("github: alice / secret\n"))
+	if err != nil {
+		t.Fatalf("WithContent() error = %v", err)
+	}
+	// Through JSON, as stored
+	stored, err := json.Marshal(dm.Message)
+	if err != nil {
+		t.Fatalf("json.Marshal() error = %v", err)
+	}
+	var m Message
+	if err := json.Unmarshal(stored, &m); err != nil {
+		t.Fatalf("json.Unmarshal() error = %v", err)
+	}
+
+	got, err := m.DecryptPassphrase([]byte("correct horse"))
+	if err != nil {
+		t.Fatalf("DecryptPassphrase() error = %v", err)
+	}
+	if !bytes.Equal(got.Content, dm.Content) {
+		t.Errorf("DecryptPassphrase() content = %q, want %q", got.Content, dm.Content)
+	}
+	// Writing another version with the decrypted key
+	edited, err := got.WithContent([]byte("edited\n"))
+	if err != nil {
+		t.Fatalf("WithContent() error = %v", err)
+	}
+	if got, err := dm.Decrypt(edited.Message); err != nil || string(got.Content) != "edited\n" {
+		t.Errorf("Decrypt() = %q, %v, want %q", got.Content, err, "edited\n")
+	}
+}
+
+func TestDecryptPassphraseWrong(t *testing.T) {
+	dm, err := NewDecryptedMessage([]byte("correct horse"))
+	if err != nil {
+		t.Fatalf("NewDecryptedMessage() error = %v", err)
+	}
+	if _, err := dm.Message.DecryptPassphrase([]byte("wrong")); err == nil {
+		t.Errorf("DecryptPassphrase() with wrong passphrase succeeded")
+	}
+}
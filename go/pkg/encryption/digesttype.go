@@ -2,6 +2,8 @@ package encryption
 
 import (
 	"crypto"
+	_ "crypto/sha1"
+	_ "crypto/sha512"
 	"fmt"
 	"hash"
 )
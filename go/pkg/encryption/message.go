@@ -8,6 +8,11 @@ import (
 type Message struct {
 	Keys    []Key   `json:"keys"`
 	Content Content `json:"content"`
+	// Revision is incremented on every write, ordering versions regardless of device clocks.
+	// Zero for messages written by versions that don't keep revisions.
+	Revision int64 `json:"revision,omitempty"`
+	// Parent is the hex SHA-256 of the stored message this one replaced.
+	Parent string `json:"parent,omitempty"`
 }
 
 func (m *Message) WithContent(content Content) Message {
@@ -33,6 +38,22 @@ func (m *Message) Decrypt(key Key, keyValue []byte) (DecryptedMessage, error) {
 	}, nil
 }
 
+// DecryptBaseKey decrypts the message with a base key kept from an earlier decrypt.
+func (m *Message) DecryptBaseKey(baseKey []byte) (DecryptedMessage, error) {
+	if len(baseKey) != baseKeySize {
+		return DecryptedMessage{}, fmt.Errorf("invalid base key size: %d", len(baseKey))
+	}
+	c, err := m.Content.Decrypt(baseKey)
+	if err != nil {
+		return DecryptedMessage{}, fmt.Errorf("failed decrypting content: %v", err)
+	}
+	return DecryptedMessage{
+		Message: *m,
+		baseKey: baseKey,
+		Content: c,
+	}, nil
+}
+
 func (m *Message) DecryptPassphrase(passphrase []byte) (DecryptedMessage, error) {
 	var errs []error
 	for _, key := range m.Keys {
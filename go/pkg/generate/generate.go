@@ -0,0 +1,277 @@
+package generate
+
+import (
+	"crypto/rand"
+	_ "embed"
+	"fmt"
+	"math"
+	"math/big"
+	"slices"
+	"strings"
+)
+
+//go:embed words.txt
+var wordsFile string
+var words = strings.Split(strings.TrimSuffix(wordsFile, "\n"), "\n")
+
+var (
+	lowerCase = symbolType{'a', 'z'}
+	upperCase = symbolType{'A', 'Z'}
+	digits    = symbolType{'0', '9'}
+	symbols   = symbolType{33, 126} // Includes the above.
+)
+
+// Type is the kind of passphrase to generate.
+type Type int
+
+const (
+	Words Type = iota
+	Symbols
+	LettersAndDigits
+	Letters
+)
+
+var Types = []Type{Words, Symbols, LettersAndDigits, Letters}
+
+// Rule is a requirement passphrases are regenerated until they meet.
+type Rule int
+
+const (
+	// ThreeSymbolTypes requires at least 3 of lower case, upper case, digits and other symbols.
+	ThreeSymbolTypes Rule = iota
+	// NotConsecutive rejects adjacent characters that are the same or next to each other, e.g. "ab".
+	NotConsecutive
+)
+
+var Rules = []Rule{ThreeSymbolTypes, NotConsecutive}
+
+// Config describes the passphrase to generate, Length is in words or in characters by the Type.
+type Config struct {
+	Type   Type
+	Length int
+	Rules  []Rule
+}
+
+type symbolType struct {
+	first, last rune
+}
+
+// Name is used to choose the type in flags.
+func (t Type) Name() string {
+	return [...]string{"words", "symbols", "letters-and-digits", "letters"}[t]
+}
+
+func (t Type) String() string {
+	return [...]string{"Words", "Letters, digits, and symbols", "Letters and digits", "Letters"}[t]
+}
+
+func (t Type) DefaultLength() int {
+	if t == Words {
+		return 4
+	}
+	return 8
+}
+
+func (t Type) MaxLength() int {
+	if t == Words {
+		return 10
+	}
+	return 20
+}
+
+// Name is used to choose the rule in flags.
+func (r Rule) Name() string {
+	return [...]string{"three-symbol-types", "not-consecutive"}[r]
+}
+
+func (r Rule) String() string {
+	return [...]string{"3 symbol types", "No consecutive symbols"}[r]
+}
+
+// Passphrase generates a passphrase, regenerating it until it meets the rules.
+func Passphrase(c Config) (string, error) {
+	if c.Length < 1 || c.Length > c.Type.MaxLength() {
+		return "", fmt.Errorf("length must be between 1 and %d", c.Type.MaxLength())
+	}
+	for {
+		result, err := candidate(c)
+		if err != nil {
+			return "", err
+		}
+		if checkRules(result, c.Rules) {
+			return result, nil
+		}
+	}
+}
+
+// Entropy estimates the bits of entropy of passphrases generated by c. Candidates rejected for not
+// having three symbol types are ignored, and the neighbors of a character rejected by not consecutive
+// are assumed to be in its symbol types.
+func Entropy(c Config) float64 {
+	switch c.Type {
+	case Words:
+		n := len(words)
+		if slices.Contains(c.Rules, NotConsecutive) {
+			n = 0
+			for _, w := range words {
+				if checkRules(w, []Rule{NotConsecutive}) {
+					n++
+				}
+			}
+		}
+		bits := float64(c.Length) * math.Log2(float64(n))
+		if slices.Contains(c.Rules, ThreeSymbolTypes) {
+			bits += math.Log2(float64(digits.count())) + math.Log2(float64(upperCase.count()))
+		}
+		return bits
+	case Symbols:
+		return charactersEntropy(c.Length, c.Rules, symbols)
+	case LettersAndDigits:
+		return charactersEntropy(c.Length, c.Rules, lowerCase, upperCase, digits)
+	case Letters:
+		if slices.Contains(c.Rules, ThreeSymbolTypes) {
+			return charactersEntropy(c.Length-1, c.Rules, lowerCase, upperCase) + math.Log2(float64(symbols.count()))
+		}
+		return charactersEntropy(c.Length, c.Rules, lowerCase, upperCase)
+	}
+	return 0
+}
+
+func candidate(c Config) (string, error) {
+	switch c.Type {
+	case Words:
+		var ws []string
+		for range c.Length {
+			i, err := randomInt(len(words))
+			if err != nil {
+				return "", err
+			}
+			ws = append(ws, words[i])
+		}
+		r := strings.Join(ws, " ")
+		if slices.Contains(c.Rules, ThreeSymbolTypes) {
+			digit, err := randomSymbol(digits)
+			if err != nil {
+				return "", err
+			}
+			upper, err := randomSymbol(upperCase)
+			if err != nil {
+				return "", err
+			}
+			r += string([]rune{' ', digit, upper})
+		}
+		return r, nil
+	case Symbols:
+		return randomSymbols(c.Length, symbols)
+	case LettersAndDigits:
+		return randomSymbols(c.Length, lowerCase, upperCase, digits)
+	case Letters:
+		r, err := randomSymbols(c.Length, lowerCase, upperCase)
+		if err != nil {
+			return "", err
+		}
+		if slices.Contains(c.Rules, ThreeSymbolTypes) {
+			symbol, err := randomSymbol(symbols)
+			if err != nil {
+				return "", err
+			}
+			r = r[:c.Length-1] + string(symbol)
+		}
+		return r, nil
+	}
+	return "", fmt.Errorf("unknown passphrase type: %d", c.Type)
+}
+
+func randomSymbols(length int, symbolTypes ...symbolType) (string, error) {
+	result := make([]rune, length)
+	for i := range result {
+		var err error
+		if result[i], err = randomSymbol(symbolTypes...); err != nil {
+			return "", err
+		}
+	}
+	return string(result), nil
+}
+
+func randomSymbol(symbolTypes ...symbolType) (rune, error) {
+	var total int
+	for _, t := range symbolTypes {
+		total += t.count()
+	}
+	result, err := randomInt(total)
+	if err != nil {
+		return 0, err
+	}
+	for _, t := range symbolTypes {
+		if result < t.count() {
+			return t.first + rune(result), nil
+		}
+		result -= t.count()
+	}
+	panic("should never happen")
+}
+
+func randomInt(n int) (int, error) {
+	i, err := rand.Int(rand.Reader, big.NewInt(int64(n)))
+	if err != nil {
+		return 0, fmt.Errorf("failed generating random number: %v", err)
+	}
+	return int(i.Int64()), nil
+}
+
+func checkRules(result string, rules []Rule) bool {
+	rs := []rune(result)
+	if slices.Contains(rules, ThreeSymbolTypes) && len(rs) >= 3 {
+		types := map[symbolType]bool{}
+		for _, r := range rs {
+			types[typeOf(r)] = true
+		}
+		if len(types) < 3 {
+			return false
+		}
+	}
+	if slices.Contains(rules, NotConsecutive) {
+		for i := 0; i < len(rs)-1; i++ {
+			if isConsecutive(rs[i], rs[i+1]) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+func isConsecutive(a, b rune) bool {
+	return a == b || a == b+1 || a == b-1
+}
+
+// typeOf returns the first symbol type r is in, defaulting to symbols.
+func typeOf(r rune) symbolType {
+	for _, t := range []symbolType{lowerCase, upperCase, digits} {
+		if t.contains(r) {
+			return t
+		}
+	}
+	return symbols
+}
+
+func charactersEntropy(length int, rules []Rule, symbolTypes ...symbolType) float64 {
+	var n int
+	for _, t := range symbolTypes {
+		n += t.count()
+	}
+	if length < 1 {
+		return 0
+	}
+	if slices.Contains(rules, NotConsecutive) {
+		return math.Log2(float64(n)) + float64(length-1)*math.Log2(float64(n-3))
+	}
+	return float64(length) * math.Log2(float64(n))
+}
+
+func (t symbolType) count() int {
+	return int(t.last-t.first) + 1
+}
+
+func (t symbolType) contains(r rune) bool {
+	return r >= t.first && r <= t.last
+}
@@ -0,0 +1,184 @@
+package generate
+
+import (
+	"math"
+	"strings"
+	"testing"
+	"unicode"
+)
+
+func TestWords(t *testing.T) {
+	if len(words) != 7135 {
+		t.Errorf("len(words) = %d, want 7135 as in the Android app's Words.kt", len(words))
+	}
+	seen := map[string]bool{}
+	for _, w := range words {
+		if w == "" || seen[w] {
+			t.Errorf("word %q is empty or repeated", w)
+		}
+		seen[w] = true
+	}
+}
+
+func TestTypeOf(t *testing.T) {
+	tests := []struct {
+		r    rune
+		want symbolType
+	}{
+		{'a', lowerCase},
+		{'z', lowerCase},
+		{'A', upperCase},
+		{'Z', upperCase},
+		{'0', digits},
+		{'9', digits},
+		{'!', symbols},
+		{'~', symbols},
+		{' ', symbols},
+	}
+	for _, tt := range tests {
+		if got := typeOf(tt.r); got != tt.want {
+			t.Errorf("typeOf(%q) = %v, want %v", tt.r, got, tt.want)
+		}
+	}
+}
+
+func TestIsConsecutive(t *testing.T) {
+	tests := []struct {
+		a, b rune
+		want bool
+	}{
+		{'a', 'a', true},
+		{'a', 'b', true},
+		{'b', 'a', true},
+		{'a', 'c', false},
+		{'9', ':', true},
+		{'z', 'A', false},
+	}
+	for _, tt := range tests {
+		if got := isConsecutive(tt.a, tt.b); got != tt.want {
+			t.Errorf("isConsecutive(%q, %q) = %v, want %v", tt.a, tt.b, got, tt.want)
+		}
+	}
+}
+
+func TestCheckRules(t *testing.T) {
+	tests := []struct {
+		name   string
+		result string
+		rules  []Rule
+		want   bool
+	}{
+		{name: "no rules", result: "aaa", want: true},
+		{name: "three types", result: "aB3", rules: []Rule{ThreeSymbolTypes}, want: true},
+		{name: "four types", result: "aB3!", rules: []Rule{ThreeSymbolTypes}, want: true},
+		{name: "two types", result: "aBcD", rules: []Rule{ThreeSymbolTypes}},
+		{name: "short", result: "aB", rules: []Rule{ThreeSymbolTypes}, want: true},
+		{name: "words with digit and upper case", result: "apple pie 3B", rules: []Rule{ThreeSymbolTypes}, want: true},
+		{name: "not consecutive", result: "acegik", rules: []Rule{NotConsecutive}, want: true},
+		{name: "repeated", result: "acca", rules: []Rule{NotConsecutive}},
+		{name: "sequence", result: "xaby", rules: []Rule{NotConsecutive}},
+		{name: "both rules", result: "aC5!", rules: Rules, want: true},
+		{name: "both rules consecutive", result: "aC5#$", rules: Rules},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := checkRules(tt.result, tt.rules); got != tt.want {
+				t.Errorf("checkRules(%q, %v) = %v, want %v", tt.result, tt.rules, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPassphraseLength(t *testing.T) {
+	for _, typ := range Types {
+		t.Run(typ.Name(), func(t *testing.T) {
+			for _, length := range []int{0, typ.MaxLength() + 1} {
+				if _, err := Passphrase(Config{Type: typ, Length: length}); err == nil {
+					t.Errorf("Passphrase() with length %d error = nil, want an error", length)
+				}
+			}
+			for _, length := range []int{1, typ.DefaultLength(), typ.MaxLength()} {
+				p, err := Passphrase(Config{Type: typ, Length: length})
+				if err != nil {
+					t.Fatalf("Passphrase() with length %d error = %v", length, err)
+				}
+				got := len([]rune(p))
+				if typ == Words {
+					got = len(strings.Fields(p))
+				}
+				if got != length {
+					t.Errorf("Passphrase() = %q, length %d, want %d", p, got, length)
+				}
+			}
+		})
+	}
+}
+
+func TestPassphraseRules(t *testing.T) {
+	for _, typ := range Types {
+		t.Run(typ.Name(), func(t *testing.T) {
+			for range 100 {
+				p, err := Passphrase(Config{Type: typ, Length: typ.DefaultLength(), Rules: Rules})
+				if err != nil {
+					t.Fatalf("Passphrase() error = %v", err)
+				}
+				if !checkRules(p, Rules) {
+					t.Fatalf("Passphrase() = %q, breaking the rules", p)
+				}
+			}
+		})
+	}
+}
+
+func TestPassphraseLettersLastSymbol(t *testing.T) {
+	for _, length := range []int{1, 8} {
+		for range 100 {
+			p, err := Passphrase(Config{Type: Letters, Length: length, Rules: []Rule{ThreeSymbolTypes}})
+			if err != nil {
+				t.Fatalf("Passphrase() error = %v", err)
+			}
+			rs := []rune(p)
+			if len(rs) != length || !symbols.contains(rs[length-1]) {
+				t.Fatalf("Passphrase() = %q, want %d characters ending with a symbol", p, length)
+			}
+			for _, r := range rs[:length-1] {
+				if !unicode.IsLetter(r) {
+					t.Fatalf("Passphrase() = %q, want letters before the last symbol", p)
+				}
+			}
+		}
+	}
+}
+
+func TestEntropy(t *testing.T) {
+	tests := []struct {
+		name   string
+		config Config
+		want   float64
+	}{
+		{name: "words", config: Config{Type: Words, Length: 4}, want: 4 * math.Log2(7135)},
+		{name: "words with three symbol types", config: Config{Type: Words, Length: 4, Rules: []Rule{ThreeSymbolTypes}},
+			want: 4*math.Log2(7135) + math.Log2(10) + math.Log2(26)},
+		{name: "symbols", config: Config{Type: Symbols, Length: 8}, want: 8 * math.Log2(94)},
+		{name: "symbols not consecutive", config: Config{Type: Symbols, Length: 8, Rules: []Rule{NotConsecutive}},
+			want: math.Log2(94) + 7*math.Log2(91)},
+		{name: "letters and digits", config: Config{Type: LettersAndDigits, Length: 8}, want: 8 * math.Log2(62)},
+		{name: "letters", config: Config{Type: Letters, Length: 8}, want: 8 * math.Log2(52)},
+		{name: "letters with three symbol types", config: Config{Type: Letters, Length: 8, Rules: []Rule{ThreeSymbolTypes}},
+			want: 7*math.Log2(52) + math.Log2(94)},
+		{name: "letter with three symbol types", config: Config{Type: Letters, Length: 1, Rules: []Rule{ThreeSymbolTypes}},
+			want: math.Log2(94)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Entropy(tt.config); math.Abs(got-tt.want) > 1e-9 {
+				t.Errorf("Entropy() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+	// Fewer words without consecutive letters
+	all := Entropy(Config{Type: Words, Length: 4})
+	if got := Entropy(Config{Type: Words, Length: 4, Rules: []Rule{NotConsecutive}}); got <= 0 || got >= all {
+		t.Errorf("Entropy() not consecutive = %v, want between 0 and %v", got, all)
+	}
+}
@@ -0,0 +1,193 @@
+package merge
+
+import (
+	"bytes"
+)
+
+var (
+	oursMarker   = []byte("<<<<<<< yours\n")
+	middleMarker = []byte("=======\n")
+	theirsMarker = []byte(">>>>>>> storage\n")
+)
+
+// Merge performs a line-based three-way merge of ours and theirs, both edited from base.
+// When both changed the same lines, the result contains conflict markers and clean is false.
+func Merge(base []byte, ours []byte, theirs []byte) (merged []byte, clean bool) {
+	b, o, t := lines(base), lines(ours), lines(theirs)
+	matchO, matchT := match(b, o), match(b, t)
+
+	var r bytes.Buffer
+	clean = true
+	i, j, k := 0, 0, 0 // Positions in base, ours and theirs.
+	for {
+		// Finding the next line that is unchanged in both
+		next := i
+		for next < len(b) && (matchO[next] < 0 || matchT[next] < 0) {
+			next++
+		}
+		if next == len(b) {
+			clean = resolve(&r, b[i:], o[j:], t[k:]) && clean
+			break
+		}
+		if next == i && matchO[next] == j && matchT[next] == k {
+			r.Write(b[i])
+			i, j, k = i+1, j+1, k+1
+			continue
+		}
+		clean = resolve(&r, b[i:next], o[j:matchO[next]], t[k:matchT[next]]) && clean
+		i, j, k = next, matchO[next], matchT[next]
+	}
+	merged = r.Bytes()
+	if clean && !endsWithNewline(ours) && !endsWithNewline(theirs) {
+		merged = bytes.TrimSuffix(merged, []byte("\n")) // Added by lines.
+	}
+	return merged, clean
+}
+
+// HasConflictMarkers returns whether content has a conflict as Merge writes it, with the ours, middle
+// and theirs marker lines in order. Any one of them alone may be part of the content, e.g. a separator.
+func HasConflictMarkers(content []byte) bool {
+	markers := [][]byte{oursMarker, middleMarker, theirsMarker}
+	next := 0
+	for _, l := range lines(content) {
+		if bytes.Equal(l, markers[next]) {
+			next++
+			if next == len(markers) {
+				return true
+			}
+		} else if bytes.Equal(l, oursMarker) {
+			next = 1 // Restarting from a later conflict.
+		}
+	}
+	return false
+}
+
+// Diff returns the lines of a and b, prefixed with "-" when only in a, "+" when only in b, or " " when in both.
+func Diff(a []byte, b []byte) []byte {
+	var r bytes.Buffer
+	walk(lines(a), lines(b), func(prefix byte, l []byte) {
+		r.WriteByte(prefix)
+		r.Write(l)
+	})
+	return r.Bytes()
+}
+
+// Union merges a and b without a common ancestor, keeping the lines of both where they differ.
+func Union(a []byte, b []byte) []byte {
+	var r bytes.Buffer
+	walk(lines(a), lines(b), func(_ byte, l []byte) {
+		r.Write(l)
+	})
+	union := r.Bytes()
+	if !endsWithNewline(a) && !endsWithNewline(b) {
+		union = bytes.TrimSuffix(union, []byte("\n")) // Added by lines.
+	}
+	return union
+}
+
+// walk calls f with the lines of a and b in order, once for lines in both.
+func walk(a [][]byte, b [][]byte, f func(prefix byte, l []byte)) {
+	m := match(a, b)
+	j := 0
+	for i, l := range a {
+		if m[i] < 0 {
+			f('-', l)
+			continue
+		}
+		for ; j < m[i]; j++ {
+			f('+', b[j])
+		}
+		f(' ', l)
+		j++
+	}
+	for ; j < len(b); j++ {
+		f('+', b[j])
+	}
+}
+
+// resolve writes a chunk that changed in at least one side, returning false on conflict.
+func resolve(r *bytes.Buffer, base [][]byte, ours [][]byte, theirs [][]byte) bool {
+	switch {
+	case equal(base, ours):
+		write(r, theirs)
+	case equal(base, theirs), equal(ours, theirs):
+		write(r, ours)
+	default:
+		r.Write(oursMarker)
+		write(r, ours)
+		r.Write(middleMarker)
+		write(r, theirs)
+		r.Write(theirsMarker)
+		return false
+	}
+	return true
+}
+
+// match returns for each line in a its index in b, or -1 when not in their longest common subsequence.
+func match(a [][]byte, b [][]byte) []int {
+	// Longest common subsequence lengths of suffixes
+	lcs := make([][]int, len(a)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			if bytes.Equal(a[i], b[j]) {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else {
+				lcs[i][j] = max(lcs[i+1][j], lcs[i][j+1])
+			}
+		}
+	}
+	// Walking the subsequence
+	m := make([]int, len(a))
+	for i := range m {
+		m[i] = -1
+	}
+	for i, j := 0, 0; i < len(a) && j < len(b); {
+		switch {
+		case bytes.Equal(a[i], b[j]):
+			m[i] = j
+			i, j = i+1, j+1
+		case lcs[i+1][j] >= lcs[i][j+1]:
+			i++
+		default:
+			j++
+		}
+	}
+	return m
+}
+
+// lines splits content to lines, making sure each ends with a newline so conflict markers stay on their own lines.
+func lines(content []byte) [][]byte {
+	if len(content) == 0 {
+		return nil
+	}
+	if !endsWithNewline(content) {
+		content = append(content[:len(content):len(content)], '\n')
+	}
+	ls := bytes.SplitAfter(content, []byte("\n"))
+	return ls[:len(ls)-1] // Empty after the last newline.
+}
+
+func endsWithNewline(content []byte) bool {
+	return len(content) > 0 && content[len(content)-1] == '\n'
+}
+
+func equal(a [][]byte, b [][]byte) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if !bytes.Equal(a[i], b[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+func write(r *bytes.Buffer, ls [][]byte) {
+	for _, l := range ls {
+		r.Write(l)
+	}
+}
@@ -0,0 +1,136 @@
+package merge
+
+import (
+	"testing"
+)
+
+func TestMerge(t *testing.T) {
+	tests := []struct {
+		name   string
+		base   string
+		ours   string
+		theirs string
+		want   string
+		clean  bool
+	}{
+		{
+			name: "unchanged",
+			base: "a\nb\nc\n", ours: "a\nb\nc\n", theirs: "a\nb\nc\n",
+			want: "a\nb\nc\n", clean: true,
+		},
+		{
+			name: "only ours changed",
+			base: "a\nb\nc\n", ours: "a\nB\nc\n", theirs: "a\nb\nc\n",
+			want: "a\nB\nc\n", clean: true,
+		},
+		{
+			name: "only theirs changed",
+			base: "a\nb\nc\n", ours: "a\nb\nc\n", theirs: "a\nb\nc\nd\n",
+			want: "a\nb\nc\nd\n", clean: true,
+		},
+		{
+			name: "both changed the same",
+			base: "a\nb\nc\n", ours: "a\nB\nc\n", theirs: "a\nB\nc\n",
+			want: "a\nB\nc\n", clean: true,
+		},
+		{
+			name: "separate lines",
+			base: "a\nb\nc\nd\ne\n", ours: "A\nb\nc\nd\ne\n", theirs: "a\nb\nc\nd\nE\n",
+			want: "A\nb\nc\nd\nE\n", clean: true,
+		},
+		{
+			name: "ours deleted, theirs added elsewhere",
+			base: "a\nb\nc\nd\n", ours: "a\nc\nd\n", theirs: "a\nb\nc\nd\ne\n",
+			want: "a\nc\nd\ne\n", clean: true,
+		},
+		{
+			name: "same line",
+			base: "a\nb\nc\n", ours: "a\nB\nc\n", theirs: "a\nX\nc\n",
+			want: "a\n<<<<<<< yours\nB\n=======\nX\n>>>>>>> storage\nc\n", clean: false,
+		},
+		{
+			name: "adjacent lines",
+			base: "a\nb\nc\nd\n", ours: "a\nB\nc\nd\n", theirs: "a\nb\nC\nd\n",
+			want: "a\n<<<<<<< yours\nB\nc\n=======\nb\nC\n>>>>>>> storage\nd\n", clean: false,
+		},
+		{
+			name: "both appended",
+			base: "a\n", ours: "a\nb\n", theirs: "a\nc\n",
+			want: "a\n<<<<<<< yours\nb\n=======\nc\n>>>>>>> storage\n", clean: false,
+		},
+		{
+			name: "no base",
+			base: "", ours: "a\n", theirs: "b\n",
+			want: "<<<<<<< yours\na\n=======\nb\n>>>>>>> storage\n", clean: false,
+		},
+		{
+			name: "no trailing newline",
+			base: "a\nb", ours: "A\nb", theirs: "a\nb",
+			want: "A\nb", clean: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, clean := Merge([]byte(tt.base), []byte(tt.ours), []byte(tt.theirs))
+			if string(got) != tt.want || clean != tt.clean {
+				t.Errorf("Merge() = %q, %v, want %q, %v", got, clean, tt.want, tt.clean)
+			}
+			if HasConflictMarkers(got) == clean {
+				t.Errorf("HasConflictMarkers(%q) = %v, want %v", got, !clean, !clean)
+			}
+		})
+	}
+}
+
+func TestHasConflictMarkers(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		want    bool
+	}{
+		{name: "conflict", content: "a\n<<<<<<< yours\nb\n=======\nc\n>>>>>>> storage\nd\n", want: true},
+		{name: "empty sides", content: "<<<<<<< yours\n=======\n>>>>>>> storage\n", want: true},
+		{name: "separator", content: "a\n=======\nb\n"},
+		{name: "ours only", content: "<<<<<<< yours\na\n"},
+		{name: "no theirs", content: "<<<<<<< yours\na\n=======\nb\n"},
+		{name: "out of order", content: ">>>>>>> storage\n=======\n<<<<<<< yours\n"},
+		{name: "separator before conflict", content: "=======\n<<<<<<< yours\na\n=======\nb\n>>>>>>> storage\n", want: true},
+		{name: "unfinished before conflict", content: "<<<<<<< yours\n<<<<<<< yours\na\n=======\nb\n>>>>>>> storage\n", want: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := HasConflictMarkers([]byte(tt.content)); got != tt.want {
+				t.Errorf("HasConflictMarkers(%q) = %v, want %v", tt.content, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestUnion(t *testing.T) {
+	tests := []struct {
+		name string
+		a    string
+		b    string
+		want string
+	}{
+		{name: "same", a: "a\nb\n", b: "a\nb\n", want: "a\nb\n"},
+		{name: "different lines", a: "a\nb\nd\n", b: "a\nc\nd\n", want: "a\nb\nc\nd\n"},
+		{name: "empty", a: "", b: "a\n", want: "a\n"},
+		{name: "no trailing newline", a: "a", b: "b", want: "a\nb"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Union([]byte(tt.a), []byte(tt.b)); string(got) != tt.want {
+				t.Errorf("Union() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDiff(t *testing.T) {
+	got := Diff([]byte("a\nb\nc\n"), []byte("a\nB\nc\nd\n"))
+	want := " a\n-b\n+B\n c\n+d\n"
+	if string(got) != want {
+		t.Errorf("Diff() = %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,137 @@
+package search
+
+import (
+	"slices"
+	"strings"
+)
+
+// Entry is a line or a section of the content. A line's key is the text before the separator and
+// its value is the text after it, a section's key is its first line and its value is the rest.
+type Entry struct {
+	Key   string
+	Value string
+	Text  string
+	Line  int // 1-based line number the entry starts at.
+}
+
+// match ranks how well the query matches an entry, by tier and then by penalty.
+type match struct {
+	entry   Entry
+	tier    int // Substring of the key, substring of the text, fuzzy in the key, fuzzy in the text.
+	penalty int // Position of a substring, or the characters skipped by a fuzzy match.
+}
+
+// Lines splits content into its non-blank lines.
+func Lines(content []byte, separator string) []Entry {
+	var entries []Entry
+	for i, line := range strings.Split(string(content), "\n") {
+		line = strings.TrimRight(line, "\r")
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+		key, value, _ := strings.Cut(line, separator)
+		entries = append(entries, Entry{
+			Key:   strings.TrimSpace(key),
+			Value: strings.TrimSpace(value),
+			Text:  line,
+			Line:  i + 1,
+		})
+	}
+	return entries
+}
+
+// Sections splits content into blocks of lines separated by blank lines.
+func Sections(content []byte) []Entry {
+	var entries []Entry
+	var section []string
+	start := 0
+	flush := func() {
+		if len(section) > 0 {
+			entries = append(entries, Entry{
+				Key:   strings.TrimSpace(section[0]),
+				Value: strings.Join(section[1:], "\n"),
+				Text:  strings.Join(section, "\n"),
+				Line:  start + 1,
+			})
+		}
+		section = nil
+	}
+	for i, line := range strings.Split(string(content), "\n") {
+		line = strings.TrimRight(line, "\r")
+		if strings.TrimSpace(line) == "" {
+			flush()
+			continue
+		}
+		if section == nil {
+			start = i
+		}
+		section = append(section, line)
+	}
+	flush()
+	return entries
+}
+
+// Find returns the entries matching query, best first. Entries match when they contain the query
+// or its characters in order, ignoring case; equally good matches keep their order in the content.
+func Find(entries []Entry, query string) []Entry {
+	q := strings.ToLower(query)
+	var matches []match
+	for _, e := range entries {
+		key, text := strings.ToLower(e.Key), strings.ToLower(e.Text)
+		if i := strings.Index(key, q); i >= 0 {
+			matches = append(matches, match{entry: e, tier: 3, penalty: i})
+		} else if i := strings.Index(text, q); i >= 0 {
+			matches = append(matches, match{entry: e, tier: 2, penalty: i})
+		} else if skipped, ok := fuzzy(key, q); ok {
+			matches = append(matches, match{entry: e, tier: 1, penalty: skipped})
+		} else if skipped, ok := fuzzy(text, q); ok {
+			matches = append(matches, match{entry: e, tier: 0, penalty: skipped})
+		}
+	}
+	slices.SortStableFunc(matches, func(a, b match) int {
+		if a.tier != b.tier {
+			return b.tier - a.tier
+		}
+		return a.penalty - b.penalty
+	})
+	var found []Entry
+	for _, m := range matches {
+		found = append(found, m.entry)
+	}
+	return found
+}
+
+// Exact returns the entry whose key is query ignoring case, when there's exactly one.
+func Exact(entries []Entry, query string) (Entry, bool) {
+	var exact []Entry
+	for _, e := range entries {
+		if strings.EqualFold(e.Key, query) {
+			exact = append(exact, e)
+		}
+	}
+	if len(exact) != 1 {
+		return Entry{}, false
+	}
+	return exact[0], true
+}
+
+// fuzzy returns whether the characters of query appear in text in order, and how many characters
+// are skipped between the first and last of them.
+func fuzzy(text, query string) (int, bool) {
+	q := []rune(query)
+	if len(q) == 0 {
+		return 0, true
+	}
+	i, skipped := 0, 0
+	for _, r := range text {
+		if r == q[i] {
+			i++
+			if i == len(q) {
+				return skipped, true
+			}
+		} else if i > 0 {
+			skipped++
+		}
+	}
+	return 0, false
+}
@@ -0,0 +1,107 @@
+package search
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestLines(t *testing.T) {
+	got := Lines([]byte("\ngithub: user \r\n  \nno separator\nurl: https://a:b@example.com\n"), ":")
+	want := []Entry{
+		{Key: "github", Value: "user", Text: "github: user ", Line: 2},
+		{Key: "no separator", Value: "", Text: "no separator", Line: 4},
+		{Key: "url", Value: "https://a:b@example.com", Text: "url: https://a:b@example.com", Line: 5},
+	}
+	if !slices.Equal(got, want) {
+		t.Errorf("Lines() = %+v, want %+v", got, want)
+	}
+}
+
+func TestSections(t *testing.T) {
+	got := Sections([]byte("\n\nbank\npin: 1234\n\n \nmail\r\nuser: me\npass: secret"))
+	want := []Entry{
+		{Key: "bank", Value: "pin: 1234", Text: "bank\npin: 1234", Line: 3},
+		{Key: "mail", Value: "user: me\npass: secret", Text: "mail\nuser: me\npass: secret", Line: 7},
+	}
+	if !slices.Equal(got, want) {
+		t.Errorf("Sections() = %+v, want %+v", got, want)
+	}
+}
+
+func TestFind(t *testing.T) {
+	entries := Lines([]byte(`gmail: me@example.com
+mail server: imap
+github: user
+bank: pass is gh
+gxxxm: fuzzy far
+gxm: fuzzy near
+site: g-m
+github: other user
+`), ":")
+	tests := []struct {
+		name  string
+		query string
+		want  []int // Lines of the entries found.
+	}{
+		{name: "key substring by position", query: "mail", want: []int{2, 1}},
+		{name: "text before fuzzy key", query: "gh", want: []int{4, 3, 8}},
+		{name: "text before fuzzy text", query: "imap", want: []int{2, 1}},
+		{name: "ignoring case", query: "GitHub", want: []int{3, 8}},
+		{name: "fuzzy key by skipped", query: "gm", want: []int{1, 6, 5, 7}},
+		{name: "fuzzy text", query: "sgm", want: []int{7}},
+		{name: "no match", query: "zzz", want: nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got []int
+			for _, e := range Find(entries, tt.query) {
+				got = append(got, e.Line)
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("Find(%q) lines = %v, want %v", tt.query, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFuzzy(t *testing.T) {
+	tests := []struct {
+		text        string
+		query       string
+		wantSkipped int
+		wantOk      bool
+	}{
+		{text: "github", query: "", wantSkipped: 0, wantOk: true},
+		{text: "github", query: "gh", wantSkipped: 2, wantOk: true},
+		{text: "github", query: "gtb", wantSkipped: 3, wantOk: true},
+		{text: "xxgh", query: "gh", wantSkipped: 0, wantOk: true},
+		{text: "github", query: "hg", wantOk: false},
+		{text: "gh", query: "ghx", wantOk: false},
+	}
+	for _, tt := range tests {
+		skipped, ok := fuzzy(tt.text, tt.query)
+		if skipped != tt.wantSkipped || ok != tt.wantOk {
+			t.Errorf("fuzzy(%q, %q) = %d, %v, want %d, %v", tt.text, tt.query, skipped, ok, tt.wantSkipped, tt.wantOk)
+		}
+	}
+}
+
+func TestExact(t *testing.T) {
+	entries := Lines([]byte("github: user\ngmail: me\nGmail: other\n"), ":")
+	tests := []struct {
+		query    string
+		wantLine int
+		wantOk   bool
+	}{
+		{query: "GITHUB", wantLine: 1, wantOk: true},
+		{query: "git"},
+		{query: "gmail"}, // Ambiguous.
+		{query: "bank"},
+	}
+	for _, tt := range tests {
+		e, ok := Exact(entries, tt.query)
+		if ok != tt.wantOk || e.Line != tt.wantLine {
+			t.Errorf("Exact(%q) = %+v, %v, want line %d, %v", tt.query, e, ok, tt.wantLine, tt.wantOk)
+		}
+	}
+}
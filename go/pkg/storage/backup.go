@@ -0,0 +1,163 @@
+package storage
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/odedniv/osafe/go/pkg/config"
+	"github.com/odedniv/osafe/go/pkg/encryption"
+)
+
+var backupsPath = path.Join(".osafe", "backups") // Relative to os.UserHomeDir.
+var backupTimeFormat = "20060102T150405.000Z"
+var backupDefaultCount = 100
+var backupDefaultMaxAge = time.Hour * 24 * 365
+var backupsConfig config.Backups
+
+// Backup is an encrypted copy of the vault from before it was overwritten.
+type Backup struct {
+	Id          string
+	CreatedTime time.Time
+	Size        int
+}
+
+// Backups returns the local backups, newest first.
+func Backups() ([]Backup, error) {
+	dir, err := userHomePath(backupsPath)
+	if err != nil {
+		return nil, err
+	}
+	entries, err := os.ReadDir(dir)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("failed listing backups: %v", err)
+	}
+
+	var backups []Backup
+	for _, entry := range entries {
+		id, ok := strings.CutSuffix(entry.Name(), ".json")
+		if !ok {
+			continue
+		}
+		createdTime, err := time.Parse(backupTimeFormat, id)
+		if err != nil {
+			continue // Not a backup.
+		}
+		info, err := entry.Info()
+		if err != nil {
+			return nil, fmt.Errorf("failed getting backup %s size: %v", id, err)
+		}
+		backups = append(backups, Backup{Id: id, CreatedTime: createdTime, Size: int(info.Size())})
+	}
+	slices.SortFunc(backups, func(a, b Backup) int { return b.CreatedTime.Compare(a.CreatedTime) })
+	return backups, nil
+}
+
+// ReadBackup returns the message in the backup with the given id.
+func ReadBackup(id string) (*encryption.Message, error) {
+	if _, err := time.Parse(backupTimeFormat, id); err != nil {
+		return nil, fmt.Errorf("invalid backup id: %s", id)
+	}
+	bytes, err := readBackup(id)
+	if err != nil {
+		return nil, err
+	}
+	return decode(bytes)
+}
+
+// SaveBackup saves m as a backup, e.g. an edit that couldn't be written, returning its id.
+func SaveBackup(m encryption.Message) (string, error) {
+	bytes, err := json.Marshal(m)
+	if err != nil {
+		return "", fmt.Errorf("failed marshaling message to backup: %v", err)
+	}
+	return backup(content{bytes, time.Now()})
+}
+
+// backup saves c before it's overwritten, unless it's the same as the newest backup, and deletes old backups.
+// Returns the id of the backup with c, or an empty string when c is empty.
+func backup(c content) (string, error) {
+	if c.bytes == nil {
+		return "", nil
+	}
+	backups, err := Backups()
+	if err != nil {
+		return "", err
+	}
+	// Skipping when the newest backup is the same
+	if len(backups) > 0 {
+		newest, err := readBackup(backups[0].Id)
+		if err != nil {
+			return "", err
+		}
+		if bytes.Equal(newest, c.bytes) {
+			return backups[0].Id, nil
+		}
+	}
+	// Writing
+	now := time.Now().UTC().Truncate(time.Millisecond)
+	if len(backups) > 0 && !now.After(backups[0].CreatedTime) {
+		now = backups[0].CreatedTime.Add(time.Millisecond) // Unique ids for backups in the same millisecond.
+	}
+	id := now.Format(backupTimeFormat)
+	if err := writeHomeFile(path.Join(backupsPath, id+".json"), c.bytes); err != nil {
+		return "", fmt.Errorf("failed writing backup: %v", err)
+	}
+	backups = slices.Insert(backups, 0, Backup{Id: id, CreatedTime: now})
+	// Deleting old backups
+	count := backupsConfig.Count
+	if count <= 0 {
+		count = backupDefaultCount
+	}
+	maxAge := time.Duration(backupsConfig.MaxAgeDays) * time.Hour * 24
+	if maxAge <= 0 {
+		maxAge = backupDefaultMaxAge
+	}
+	for i, b := range backups[1:] {
+		if i+1 < count && now.Sub(b.CreatedTime) <= maxAge {
+			continue
+		}
+		if err := deleteBackup(b.Id); err != nil {
+			return "", err
+		}
+	}
+	return id, nil
+}
+
+// tryBackup backs up c, only warning on failure so backups never prevent writing.
+func tryBackup(c content) {
+	if _, err := backup(c); err != nil {
+		warn("failed backing up before write: %v", err)
+	}
+}
+
+func readBackup(id string) ([]byte, error) {
+	name, err := userHomePath(path.Join(backupsPath, id+".json"))
+	if err != nil {
+		return nil, err
+	}
+	bytes, err := os.ReadFile(name)
+	if err != nil {
+		return nil, fmt.Errorf("failed reading backup %s: %v", id, err)
+	}
+	return bytes, nil
+}
+
+func deleteBackup(id string) error {
+	name, err := userHomePath(path.Join(backupsPath, id+".json"))
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(name); err != nil {
+		return fmt.Errorf("failed deleting backup %s: %v", id, err)
+	}
+	return nil
+}
@@ -0,0 +1,133 @@
+package storage
+
+import (
+	"path"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/odedniv/osafe/go/pkg/config"
+)
+
+// useBackups keeps backups in a new HOME for the test, with c as their config.
+func useBackups(t *testing.T, c config.Backups) {
+	t.Setenv("HOME", t.TempDir())
+	previous := backupsConfig
+	t.Cleanup(func() { backupsConfig = previous })
+	backupsConfig = c
+}
+
+// writeBackupAt writes a backup as if it was made at createdTime.
+func writeBackupAt(t *testing.T, createdTime time.Time, bytes string) string {
+	id := createdTime.UTC().Format(backupTimeFormat)
+	if err := writeHomeFile(path.Join(backupsPath, id+".json"), []byte(bytes)); err != nil {
+		t.Fatal(err)
+	}
+	return id
+}
+
+func backupIds(t *testing.T) []string {
+	backups, err := Backups()
+	if err != nil {
+		t.Fatalf("Backups() error = %v", err)
+	}
+	var ids []string
+	for _, b := range backups {
+		ids = append(ids, b.Id)
+	}
+	return ids
+}
+
+func TestBackupRetentionCount(t *testing.T) {
+	useBackups(t, config.Backups{Count: 3})
+	var ids []string
+	for i := range 5 {
+		id, err := backup(content{bytes: []byte{byte(i)}})
+		if err != nil {
+			t.Fatalf("backup() error = %v", err)
+		}
+		ids = slices.Insert(ids, 0, id)
+	}
+	if got := backupIds(t); !slices.Equal(got, ids[:3]) {
+		t.Errorf("backups = %v, want the newest %v", got, ids[:3])
+	}
+}
+
+func TestBackupRetentionAge(t *testing.T) {
+	useBackups(t, config.Backups{MaxAgeDays: 10})
+	now := time.Now()
+	old := writeBackupAt(t, now.Add(-time.Hour*24*11), "old")
+	recent := writeBackupAt(t, now.Add(-time.Hour*24*9), "recent")
+
+	id, err := backup(content{bytes: []byte("new")})
+	if err != nil {
+		t.Fatalf("backup() error = %v", err)
+	}
+	if got, want := backupIds(t), []string{id, recent}; !slices.Equal(got, want) {
+		t.Errorf("backups = %v, want %v without %s", got, want, old)
+	}
+}
+
+func TestBackupRetentionKeepsNewest(t *testing.T) {
+	useBackups(t, config.Backups{Count: 1, MaxAgeDays: 1})
+	for _, bytes := range []string{"first", "second"} {
+		id, err := backup(content{bytes: []byte(bytes)})
+		if err != nil {
+			t.Fatalf("backup() error = %v", err)
+		}
+		if got := backupIds(t); !slices.Equal(got, []string{id}) {
+			t.Errorf("backups = %v, want only %s", got, id)
+		}
+	}
+}
+
+func TestBackupSameMillisecond(t *testing.T) {
+	useBackups(t, config.Backups{})
+	// A backup in the future, e.g. made in the same millisecond by a faster clock
+	future := time.Now().UTC().Add(time.Hour).Truncate(time.Millisecond)
+	writeBackupAt(t, future, "future")
+
+	id, err := backup(content{bytes: []byte("now")})
+	if err != nil {
+		t.Fatalf("backup() error = %v", err)
+	}
+	if want := future.Add(time.Millisecond).Format(backupTimeFormat); id != want {
+		t.Errorf("backup() = %s, want %s", id, want)
+	}
+	// Quick successive backups
+	next, err := backup(content{bytes: []byte("next")})
+	if err != nil || next == id {
+		t.Errorf("backup() = %s, %v, want a new id", next, err)
+	}
+	if got := backupIds(t); len(got) != 3 {
+		t.Errorf("backups = %v, want 3", got)
+	}
+}
+
+func TestBackupDedup(t *testing.T) {
+	useBackups(t, config.Backups{})
+	first, err := backup(content{bytes: []byte("same")})
+	if err != nil {
+		t.Fatalf("backup() error = %v", err)
+	}
+	// Same as the newest
+	if id, err := backup(content{bytes: []byte("same")}); err != nil || id != first {
+		t.Errorf("backup() = %s, %v, want the existing %s", id, err, first)
+	}
+	// Only compared with the newest
+	second, err := backup(content{bytes: []byte("other")})
+	if err != nil {
+		t.Fatalf("backup() error = %v", err)
+	}
+	third, err := backup(content{bytes: []byte("same")})
+	if err != nil || third == first {
+		t.Errorf("backup() = %s, %v, want a new backup", third, err)
+	}
+	if got, want := backupIds(t), []string{third, second, first}; !slices.Equal(got, want) {
+		t.Errorf("backups = %v, want %v", got, want)
+	}
+	// Nothing to back up
+	if id, err := backup(content{}); err != nil || id != "" {
+		t.Errorf("backup() of nothing = %s, %v, want none", id, err)
+	}
+}
@@ -3,15 +3,28 @@ package storage
 import (
 	"bytes"
 	"context"
+	"crypto/rand"
+	"crypto/subtle"
 	_ "embed"
+	"encoding/base64"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
+	"net"
 	"net/http"
+	"net/url"
 	"os"
+	"os/exec"
 	"path"
+	"runtime"
+	"slices"
+	"strings"
 	"time"
 
+	"github.com/odedniv/osafe/go/pkg/config"
+	"github.com/odedniv/osafe/go/pkg/encryption"
+
 	"golang.org/x/oauth2"
 	"golang.org/x/oauth2/google"
 	"google.golang.org/api/drive/v3"
@@ -21,120 +34,481 @@ import (
 //go:embed google-oauth.json
 var googleOauthConfig []byte
 var googleTokenFilePath = path.Join(".osafe", "google-creds.json") // Relative to os.UserHomeDir.
+var googleTokenKeyringAccount = "google-creds-key"
+var googleTokenKeySize = 64
+var tokenKeyring = systemKeyring()
+var driveAuthTimeout = time.Minute * 5
+var errDriveHeadless = errors.New("can't authorize in a local browser")
+var driveFolderMimeType = "application/vnd.google-apps.folder"
 
 type driveStorage struct {
-	srv *drive.Service
+	config config.Drive
+	// endpoint and client replace the Drive API and the authorized client when set, e.g. with a
+	// drivefake.Server.
+	endpoint string
+	client   *http.Client
+	srv      *drive.Service
+	parent   string // Resolved folder ID.
+	version  int64  // Drive's file version, 0 when the file didn't exist.
+	known    bool
+}
+
+// driveTokenFile holds the Google OAuth token encrypted with a key from the OS keyring.
+// Without a keyring the file holds the plain oauth2.Token instead.
+type driveTokenFile struct {
+	Encrypted *encryption.Content `json:"encrypted,omitempty"`
 }
 
 type driveFileMetadata struct {
 	fileId       string
 	modifiedTime time.Time
+	version      int64
+}
+
+func (s *driveStorage) name() string {
+	return "drive"
 }
 
-func (s *driveStorage) read() (content, error) {
+func (s *driveStorage) read(ctx context.Context) (content, error) {
 	if err := s.prepare(); err != nil {
 		return content{}, fmt.Errorf("failed preparing drive storage for read: %v", err)
 	}
 	// Query
-	m, err := s.query()
+	m, err := s.query(ctx)
 	if err != nil {
 		return content{}, fmt.Errorf("failed querying drive storage for read: %v", err)
 	} else if m.fileId == "" {
+		s.version, s.known = 0, true
 		return content{}, nil
 	}
 	// Downloading
+	bytes, err := s.download(ctx, m.fileId)
+	if err != nil {
+		return content{}, err
+	}
+	s.version, s.known = m.version, true
+	return content{bytes, m.modifiedTime}, nil
+}
+
+func (s *driveStorage) download(ctx context.Context, fileId string) ([]byte, error) {
 	r, err := s.srv.
 		Files.
-		Get(m.fileId).
+		Get(fileId).
+		Context(ctx).
 		Download()
 	if err != nil {
-		return content{}, fmt.Errorf("failed downloading from drive storage: %v", err)
+		return nil, fmt.Errorf("failed downloading from drive storage: %v", err)
 	}
 	defer r.Body.Close()
 
 	bytes, err := io.ReadAll(r.Body)
 	if err != nil {
-		return content{}, fmt.Errorf("failed reading from drive storage: %v", err)
+		return nil, fmt.Errorf("failed reading from drive storage: %v", err)
 	}
-	return content{bytes, m.modifiedTime}, nil
+	return bytes, nil
 }
 
-func (s *driveStorage) write(c content) error {
+func (s *driveStorage) write(ctx context.Context, c content) error {
 	if err := s.prepare(); err != nil {
 		return fmt.Errorf("failed preparing drive storage for write: %v", err)
 	}
 	// Query
-	m, err := s.query()
+	m, err := s.query(ctx)
 	if err != nil {
 		return fmt.Errorf("failed querying drive storage for write: %v", err)
 	}
+	// Drive has no conditional updates, so this is only best effort
+	if s.known && m.version != s.version {
+		return fmt.Errorf("drive storage was modified since it was read: %w", ErrConflict)
+	}
 	// Create or update
+	var version int64
 	if m.fileId == "" {
-		err = s.create(c)
+		version, err = s.create(ctx, c)
 	} else {
-		err = s.update(c, m.fileId)
+		version, err = s.update(ctx, c, m.fileId)
 	}
 	if err != nil {
+		s.known = false
 		return fmt.Errorf("failed create or update drive storage: %v", err)
 	}
+	s.version, s.known = version, true
 	return nil
 }
 
-func (s *driveStorage) create(c content) error {
-	_, err := s.srv.
+func (s *driveStorage) create(ctx context.Context, c content) (int64, error) {
+	parent, err := s.parentId(ctx, true)
+	if err != nil {
+		return 0, err
+	}
+	f, err := s.srv.
 		Files.
 		Create(
 			&drive.File{
-				Name:         storageFilename,
+				Name:         s.filename(),
+				Parents:      []string{parent},
 				ModifiedTime: c.modifiedTime.Format(time.RFC3339),
 			}).
 		Media(bytes.NewReader(c.bytes)).
+		Fields("version").
+		Context(ctx).
 		Do()
 	if err != nil {
-		return fmt.Errorf("failed inserting drive storage: %v", err)
+		return 0, fmt.Errorf("failed inserting drive storage: %v", err)
 	}
-	return nil
+	return f.Version, nil
 }
 
-func (s *driveStorage) update(c content, fileId string) error {
-	_, err := s.srv.
+func (s *driveStorage) update(ctx context.Context, c content, fileId string) (int64, error) {
+	f, err := s.srv.
 		Files.
 		Update(
 			fileId,
 			&drive.File{
-				Name:         storageFilename,
+				Name:         s.filename(),
 				ModifiedTime: c.modifiedTime.Format(time.RFC3339),
 			}).
 		Media(bytes.NewReader(c.bytes)).
+		Fields("version").
+		Context(ctx).
 		Do()
 	if err != nil {
-		return fmt.Errorf("failed updating drive storage: %v", err)
+		return 0, fmt.Errorf("failed updating drive storage: %v", err)
 	}
-	return nil
+	return f.Version, nil
 }
 
-func (s *driveStorage) query() (driveFileMetadata, error) {
+func (s *driveStorage) query(ctx context.Context) (driveFileMetadata, error) {
+	ms, err := s.list(ctx)
+	if err != nil {
+		return driveFileMetadata{}, err
+	}
+
+	switch len(ms) {
+	case 0:
+		return driveFileMetadata{}, nil
+	case 1:
+	default:
+		// Happens after offline edits from several devices
+		warn("%d %s files in Drive, using the newest. Run `osafe drive-dedupe` to resolve.", len(ms), s.filename())
+	}
+	return ms[0], nil
+}
+
+// list returns all vault files in the configured location, newest first.
+func (s *driveStorage) list(ctx context.Context) ([]driveFileMetadata, error) {
+	parent, err := s.parentId(ctx, false)
+	if err != nil {
+		return nil, err
+	} else if parent == "" {
+		return nil, nil // Folder doesn't exist yet.
+	}
 	fileList, err := s.srv.
 		Files.
 		List().
-		Q(fmt.Sprintf("name = '%s' and 'root' in parents and trashed = false", storageFilename)).
-		Fields("files(id, modifiedTime)").
+		Q(fmt.Sprintf("name = '%s' and '%s' in parents and trashed = false", driveQueryEscape(s.filename()), parent)).
+		Spaces(s.space()).
+		OrderBy("modifiedTime desc").
+		Fields("files(id, modifiedTime, version)").
+		Context(ctx).
 		Do()
 	if err != nil {
-		return driveFileMetadata{}, fmt.Errorf("failed querying drive storage: %v", err)
+		return nil, fmt.Errorf("failed querying drive storage: %v", err)
 	}
 
-	switch len(fileList.Files) {
-	case 0:
-	case 1:
-		fileId := fileList.Files[0].Id
-		modifiedTime, err := time.Parse(time.RFC3339, fileList.Files[0].ModifiedTime)
+	var ms []driveFileMetadata
+	for _, f := range fileList.Files {
+		modifiedTime, err := time.Parse(time.RFC3339, f.ModifiedTime)
+		if err != nil {
+			return nil, fmt.Errorf("failed parsing drive storage modified time: %v", err)
+		}
+		ms = append(ms, driveFileMetadata{f.Id, modifiedTime, f.Version})
+	}
+	return ms, nil
+}
+
+// DriveDuplicate is one of several vault files in the configured Drive location.
+type DriveDuplicate struct {
+	Id           string
+	ModifiedTime time.Time
+	Message      encryption.Message
+}
+
+// DriveDuplicates reads all vault files in the configured Drive location, newest first.
+func DriveDuplicates() ([]DriveDuplicate, error) {
+	s, err := preparedDriveStorage()
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), operationTimeout) // After authorizing.
+	defer cancel()
+	ms, err := s.list(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var ds []DriveDuplicate
+	for _, m := range ms {
+		bytes, err := s.download(ctx, m.fileId)
+		if err != nil {
+			return nil, err
+		}
+		dm, err := decode(bytes)
 		if err != nil {
-			return driveFileMetadata{}, fmt.Errorf("failed parsing drive storage modified time: %v", err)
+			return nil, err
 		}
-		return driveFileMetadata{fileId, modifiedTime}, nil
+		ds = append(ds, DriveDuplicate{m.fileId, m.modifiedTime, *dm})
+	}
+	return ds, nil
+}
+
+// DriveTrash moves vault files, as listed by DriveDuplicates, to the Drive trash.
+func DriveTrash(ids []string) error {
+	s, err := preparedDriveStorage()
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), operationTimeout) // After authorizing.
+	defer cancel()
+	for _, id := range ids {
+		if err := s.trash(ctx, id); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *driveStorage) trash(ctx context.Context, fileId string) error {
+	_, err := s.srv.
+		Files.
+		Update(fileId, &drive.File{Trashed: true}).
+		Context(ctx).
+		Do()
+	if err != nil {
+		return fmt.Errorf("failed trashing drive file: %v", err)
+	}
+	return nil
+}
+
+// parentId returns the ID of the folder holding the vault file, or an empty string when it
+// doesn't exist. When create is set, missing folders are created instead.
+func (s *driveStorage) parentId(ctx context.Context, create bool) (string, error) {
+	if s.parent != "" {
+		return s.parent, nil
+	}
+	parent := "root"
+	if s.config.AppData {
+		if s.config.FolderId != "" {
+			return "", errors.New("drive folderId can't be used with appData")
+		}
+		parent = "appDataFolder"
+	} else if s.config.FolderId != "" {
+		parent = s.config.FolderId
+	}
+
+	for _, name := range strings.Split(s.config.FolderPath, "/") {
+		if name == "" {
+			continue
+		}
+		fileList, err := s.srv.
+			Files.
+			List().
+			Q(fmt.Sprintf("name = '%s' and '%s' in parents and mimeType = '%s' and trashed = false",
+				driveQueryEscape(name), parent, driveFolderMimeType)).
+			Spaces(s.space()).
+			Fields("files(id)").
+			Context(ctx).
+			Do()
+		if err != nil {
+			return "", fmt.Errorf("failed querying drive folder %s: %v", name, err)
+		}
+		if len(fileList.Files) > 0 {
+			parent = fileList.Files[0].Id
+			continue
+		}
+		if !create {
+			return "", nil
+		}
+		f, err := s.srv.
+			Files.
+			Create(&drive.File{Name: name, Parents: []string{parent}, MimeType: driveFolderMimeType}).
+			Fields("id").
+			Context(ctx).
+			Do()
+		if err != nil {
+			return "", fmt.Errorf("failed creating drive folder %s: %v", name, err)
+		}
+		parent = f.Id
+	}
+	s.parent = parent
+	return parent, nil
+}
+
+func (s *driveStorage) filename() string {
+	if s.config.Filename != "" {
+		return s.config.Filename
+	}
+	return storageFilename
+}
+
+func (s *driveStorage) space() string {
+	if s.config.AppData {
+		return "appDataFolder"
+	}
+	return "drive"
+}
+
+// DriveMigrate moves the vault file from another Drive location to the configured one.
+func DriveMigrate(from config.Drive) error {
+	target, err := preparedDriveStorage()
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), operationTimeout) // After authorizing.
+	defer cancel()
+	source := &driveStorage{config: from, srv: target.srv}
+	// Reading source
+	sm, err := source.query(ctx)
+	if err != nil {
+		return fmt.Errorf("failed querying drive migration source: %v", err)
+	} else if sm.fileId == "" {
+		return fmt.Errorf("no %s file in drive migration source", source.filename())
+	}
+	c, err := source.read(ctx)
+	if err != nil {
+		return fmt.Errorf("failed reading drive migration source: %v", err)
+	}
+	// Writing target
+	tm, err := target.query(ctx)
+	if err != nil {
+		return fmt.Errorf("failed querying drive migration target: %v", err)
+	} else if tm.fileId == sm.fileId {
+		return errors.New("drive migration source and target are the same")
+	}
+	existing, err := target.read(ctx)
+	if err != nil {
+		return fmt.Errorf("failed reading drive migration target: %v", err)
+	}
+	if existing.bytes == nil {
+		if err := target.write(ctx, c); err != nil {
+			return fmt.Errorf("failed writing drive migration target: %v", err)
+		}
+	} else if !bytes.Equal(existing.bytes, c.bytes) {
+		return fmt.Errorf("a different %s file already exists in drive migration target", target.filename())
+	}
+	// Trashing source
+	if err := source.trash(ctx, sm.fileId); err != nil {
+		return fmt.Errorf("failed trashing drive migration source: %v", err)
+	}
+	return nil
+}
+
+// DriveRevision is a past version of the vault file kept by Drive.
+type DriveRevision struct {
+	Id           string
+	ModifiedTime time.Time
+	Size         int64
+}
+
+// DriveRevisions lists the revisions Drive kept of the vault file, oldest first.
+func DriveRevisions() ([]DriveRevision, error) {
+	s, err := preparedDriveStorage()
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), operationTimeout) // After authorizing.
+	defer cancel()
+	fileId, err := s.existingFileId(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var revisions []DriveRevision
+	err = s.srv.
+		Revisions.
+		List(fileId).
+		Fields("nextPageToken", "revisions(id, modifiedTime, size)").
+		Pages(ctx, func(rl *drive.RevisionList) error {
+			for _, r := range rl.Revisions {
+				modifiedTime, err := time.Parse(time.RFC3339, r.ModifiedTime)
+				if err != nil {
+					return fmt.Errorf("failed parsing drive revision modified time: %v", err)
+				}
+				revisions = append(revisions, DriveRevision{r.Id, modifiedTime, r.Size})
+			}
+			return nil
+		})
+	if err != nil {
+		return nil, fmt.Errorf("failed listing drive revisions: %v", err)
+	}
+	return revisions, nil
+}
+
+// ReadDriveRevision reads a revision of the vault file as listed by DriveRevisions.
+func ReadDriveRevision(id string) (*encryption.Message, error) {
+	s, err := preparedDriveStorage()
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), operationTimeout) // After authorizing.
+	defer cancel()
+	fileId, err := s.existingFileId(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	r, err := s.srv.
+		Revisions.
+		Get(fileId, id).
+		Context(ctx).
+		Download()
+	if err != nil {
+		return nil, fmt.Errorf("failed downloading drive revision: %v", err)
+	}
+	defer r.Body.Close()
+
+	bytes, err := io.ReadAll(r.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed reading drive revision: %v", err)
+	}
+	return decode(bytes)
+}
+
+// preparedDriveStorage returns the configured drive storage, authorized before any operation's timeout starts.
+func preparedDriveStorage() (*driveStorage, error) {
+	s, err := configuredDriveStorage()
+	if err != nil {
+		return nil, err
+	}
+	if err := s.prepare(); err != nil {
+		return nil, fmt.Errorf("failed preparing drive storage: %v", err)
+	}
+	return s, nil
+}
+
+// existingFileId returns the ID of the vault file, failing when it doesn't exist.
+func (s *driveStorage) existingFileId(ctx context.Context) (string, error) {
+	m, err := s.query(ctx)
+	if err != nil {
+		return "", fmt.Errorf("failed querying drive storage: %v", err)
+	} else if m.fileId == "" {
+		return "", fmt.Errorf("no %s file in Drive", s.filename())
+	}
+	return m.fileId, nil
+}
+
+func configuredDriveStorage() (*driveStorage, error) {
+	if err := prepareStorages(); err != nil {
+		return nil, err
+	}
+	i := slices.IndexFunc(storages, func(s storage) bool {
+		_, ok := s.(*driveStorage)
+		return ok
+	})
+	if i == -1 {
+		return nil, errors.New("drive storage is not configured")
 	}
-	return driveFileMetadata{}, fmt.Errorf("more than one %s file in Drive.", storageFilename)
+	return storages[i].(*driveStorage), nil
 }
 
 func (s *driveStorage) prepare() error {
@@ -142,31 +516,87 @@ func (s *driveStorage) prepare() error {
 		return nil // Already prepared.
 	}
 	// Creating service
-	client, err := getDriveClient()
-	if err != nil {
-		return fmt.Errorf("failed getting drive storage client: %v", err)
+	client := s.client
+	if client == nil {
+		var err error
+		client, err = getDriveClient(s.config)
+		if err != nil {
+			return fmt.Errorf("failed getting drive storage client: %v", err)
+		}
+	}
+	opts := []option.ClientOption{option.WithHTTPClient(client)}
+	if s.endpoint != "" {
+		opts = append(opts, option.WithEndpoint(s.endpoint))
 	}
-	s.srv, err = drive.NewService(context.Background(), option.WithHTTPClient(client))
+	var err error
+	s.srv, err = drive.NewService(context.Background(), opts...)
 	if err != nil {
 		return fmt.Errorf("failed creating drive storage service: %v", err)
 	}
 	return nil
 }
 
-func getDriveClient() (*http.Client, error) {
-	config, err := google.ConfigFromJSON(googleOauthConfig, drive.DriveFileScope)
+func getDriveClient(c config.Drive) (*http.Client, error) {
+	oauthConfig, err := getDriveOauthConfig(c)
 	if err != nil {
-		return nil, fmt.Errorf("failed parsing Google OAuth config: %v", err)
+		return nil, err
 	}
 
 	tok, err := getDriveTokenFromFile()
-	if os.IsNotExist(err) {
-		tok, err = getDriveTokenFromWeb(config)
+	if errors.Is(err, os.ErrNotExist) {
+		tok, err = authorizeDrive(c, oauthConfig)
 	}
 	if err != nil {
 		return nil, fmt.Errorf("failed getting Google OAuth web token: %v", err)
 	}
-	return config.Client(context.Background(), tok), nil
+	ts := &driveTokenSource{oauthConfig: oauthConfig}
+	ts.reset(tok)
+	// Refreshing now rather than during a request, which can't wait for authorizing again
+	if _, err := ts.Token(); errors.Is(err, errDriveAuthRevoked) {
+		fmt.Println("Google Drive authorization was revoked or expired, authorizing again.")
+		tok, err = authorizeDrive(c, oauthConfig)
+		if err != nil {
+			return nil, fmt.Errorf("failed authorizing drive again: %v", err)
+		}
+		ts.reset(tok)
+	} else if err != nil {
+		return nil, fmt.Errorf("failed refreshing Google OAuth token: %v", err)
+	}
+	return &http.Client{Transport: &oauth2.Transport{Source: ts, Base: &retryTransport{base: http.DefaultTransport}}}, nil
+}
+
+// authorizeDrive interactively gets and saves a new token.
+func authorizeDrive(c config.Drive, oauthConfig *oauth2.Config) (*oauth2.Token, error) {
+	switch c.Auth {
+	case config.DriveAuthBrowser, config.DriveAuthPaste:
+		return getDriveTokenFromWeb(oauthConfig, c.Auth == config.DriveAuthPaste)
+	case config.DriveAuthDevice:
+		return getDriveTokenFromDevice(oauthConfig)
+	default:
+		return nil, fmt.Errorf("unknown drive auth: %s", c.Auth)
+	}
+}
+
+func getDriveOauthConfig(c config.Drive) (*oauth2.Config, error) {
+	clientConfig := googleOauthConfig
+	if c.OAuthClientFile != "" {
+		name, err := userHomePath(c.OAuthClientFile)
+		if err != nil {
+			return nil, err
+		}
+		clientConfig, err = os.ReadFile(name)
+		if err != nil {
+			return nil, fmt.Errorf("failed reading Google OAuth client file: %v", err)
+		}
+	}
+	oauthConfig, err := google.ConfigFromJSON(clientConfig, drive.DriveFileScope, drive.DriveAppdataScope)
+	if err != nil {
+		return nil, fmt.Errorf("failed parsing Google OAuth config: %v", err)
+	}
+	if oauthConfig.Endpoint.DeviceAuthURL == "" {
+		oauthConfig.Endpoint.DeviceAuthURL = google.Endpoint.DeviceAuthURL
+	}
+	return oauthConfig, nil
 }
 
 func getDriveTokenFromFile() (*oauth2.Token, error) {
@@ -175,30 +605,89 @@ func getDriveTokenFromFile() (*oauth2.Token, error) {
 		return nil, fmt.Errorf("failed getting Google OAuth token file name for read: %v", err)
 	}
 
-	f, err := os.Open(name)
+	bytes, err := os.ReadFile(name)
+	if err != nil {
+		return nil, fmt.Errorf("failed reading Google OAuth token file: %w", err)
+	}
+	var file driveTokenFile
+	err = json.Unmarshal(bytes, &file)
 	if err != nil {
-		return nil, fmt.Errorf("failed opening Google OAuth token file for read: %v", err)
+		return nil, fmt.Errorf("failed parsing Google OAuth token file: %v", err)
 	}
-	defer f.Close()
+	if file.Encrypted != nil {
+		bytes, err = decryptDriveToken(*file.Encrypted)
+		if err != nil {
+			return nil, err
+		}
+	} // Otherwise saved before encryption, or without a keyring.
 
 	var tok oauth2.Token
-	err = json.NewDecoder(f).Decode(&tok)
+	err = json.Unmarshal(bytes, &tok)
 	if err != nil {
-		return nil, fmt.Errorf("failed parsing Google OAuth token file: %v", err)
+		return nil, fmt.Errorf("failed parsing Google OAuth token: %v", err)
+	}
+	if file.Encrypted == nil && tokenKeyring != nil {
+		// Encrypting a token saved before encryption, staying unencrypted while the keyring is unusable
+		if encrypted, err := encryptDriveToken(bytes); err == nil {
+			if err := writeDriveTokenFile(name, encrypted); err != nil {
+				warn("failed encrypting Google OAuth token file: %v", err)
+			}
+		}
 	}
 	return &tok, nil
 }
 
-func getDriveTokenFromWeb(config *oauth2.Config) (*oauth2.Token, error) {
-	authURL := config.AuthCodeURL("state-token", oauth2.AccessTypeOffline)
-	fmt.Printf("Go to the following link in your browser then type the "+
-		"authorization code: \n%v\n", authURL)
-	var authCode string
-	if _, err := fmt.Scan(&authCode); err != nil {
-		return nil, fmt.Errorf("failed scanning Google OAuth auth code: %v", err)
+// decryptDriveToken returns an error wrapping os.ErrNotExist when the key is lost, so that
+// authorization starts over.
+func decryptDriveToken(c encryption.Content) ([]byte, error) {
+	if tokenKeyring == nil {
+		return nil, fmt.Errorf("Google OAuth token file is encrypted, but there's no keyring: %w", os.ErrNotExist)
+	}
+	key, err := tokenKeyring.get(googleTokenKeyringAccount)
+	if err != nil {
+		return nil, fmt.Errorf("failed getting Google OAuth token key: %w", err)
+	}
+	bytes, err := c.Decrypt(key)
+	if err != nil {
+		return nil, fmt.Errorf("failed decrypting Google OAuth token (%v): %w", err, os.ErrNotExist)
+	}
+	return bytes, nil
+}
+
+func getDriveTokenKey() ([]byte, error) {
+	key, err := tokenKeyring.get(googleTokenKeyringAccount)
+	if errors.Is(err, os.ErrNotExist) {
+		key = make([]byte, googleTokenKeySize)
+		if _, err := rand.Read(key); err != nil {
+			return nil, fmt.Errorf("failed generating random Google OAuth token key: %v", err)
+		}
+		err = tokenKeyring.set(googleTokenKeyringAccount, key)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed getting Google OAuth token key: %v", err)
+	}
+	return key, nil
+}
+
+func getDriveTokenFromWeb(config *oauth2.Config, paste bool) (*oauth2.Token, error) {
+	state, err := randomDriveAuthState()
+	if err != nil {
+		return nil, err
+	}
+	verifier := oauth2.GenerateVerifier()
+	// Authorizing
+	authCode, redirectConfig, err := "", config, errDriveHeadless
+	if !paste && !headless() {
+		authCode, redirectConfig, err = getDriveAuthCodeFromLoopback(config, state, verifier)
+	}
+	if errors.Is(err, errDriveHeadless) {
+		authCode, err = getDriveAuthCodeFromPaste(config, state, verifier)
+	}
+	if err != nil {
+		return nil, err
 	}
 
-	tok, err := config.Exchange(context.TODO(), authCode)
+	tok, err := redirectConfig.Exchange(context.TODO(), authCode, oauth2.VerifierOption(verifier))
 	if err != nil {
 		return nil, fmt.Errorf("failed exchanging Google OAuth auth code: %v", err)
 	}
@@ -210,21 +699,160 @@ func getDriveTokenFromWeb(config *oauth2.Config) (*oauth2.Token, error) {
 	return tok, nil
 }
 
+// getDriveTokenFromDevice uses the device authorization flow, for machines without a browser.
+func getDriveTokenFromDevice(config *oauth2.Config) (*oauth2.Token, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), driveAuthTimeout)
+	defer cancel()
+
+	da, err := config.DeviceAuth(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed requesting Google OAuth device code: %v", err)
+	}
+	fmt.Printf("Go to the following link on any device, then enter the code %s: \n%v\n", da.UserCode, da.VerificationURI)
+
+	tok, err := config.DeviceAccessToken(ctx, da)
+	if err != nil {
+		return nil, fmt.Errorf("failed polling Google OAuth device token: %v", err)
+	}
+
+	err = saveDriveTokenToFile(tok)
+	if err != nil {
+		return nil, fmt.Errorf("failed saving Google OAuth token file: %v", err)
+	}
+	return tok, nil
+}
+
+// getDriveAuthCodeFromLoopback catches the redirect on a local listener, returning the config with its
+// redirect URL for the exchange. Returns errDriveHeadless when it can't listen.
+func getDriveAuthCodeFromLoopback(config *oauth2.Config, state string, verifier string) (string, *oauth2.Config, error) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return "", nil, fmt.Errorf("failed listening for Google OAuth redirect (%v): %w", err, errDriveHeadless)
+	}
+	defer l.Close()
+	loopbackConfig := *config
+	loopbackConfig.RedirectURL = fmt.Sprintf("http://%s/", l.Addr())
+
+	type result struct {
+		authCode string
+		err      error
+	}
+	ch := make(chan result, 1)
+	srv := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/" {
+			http.NotFound(w, r) // E.g. favicon.
+			return
+		}
+		authCode, err := getDriveAuthCodeFromQuery(r.URL.Query(), state)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+		} else {
+			fmt.Fprintln(w, "OSafe is authorized, you can close this tab.")
+		}
+		select {
+		case ch <- result{authCode, err}:
+		default: // Already got a result.
+		}
+	})}
+	go srv.Serve(l)
+	defer srv.Close()
+
+	authURL := loopbackConfig.AuthCodeURL(state, oauth2.AccessTypeOffline, oauth2.S256ChallengeOption(verifier))
+	fmt.Printf("Authorize in your browser, or go to the following link: \n%v\n", authURL)
+	if err := openBrowser(authURL); err != nil {
+		warn("failed opening browser: %v", err)
+	}
+	select {
+	case r := <-ch:
+		return r.authCode, &loopbackConfig, r.err
+	case <-time.After(driveAuthTimeout):
+		return "", nil, errors.New("timed out waiting for Google OAuth redirect")
+	}
+}
+
+// getDriveAuthCodeFromPaste lets the user copy the redirect URL from a browser on another machine.
+func getDriveAuthCodeFromPaste(config *oauth2.Config, state string, verifier string) (string, error) {
+	authURL := config.AuthCodeURL(state, oauth2.AccessTypeOffline, oauth2.S256ChallengeOption(verifier))
+	fmt.Printf("Go to the following link in your browser, then type the address "+
+		"of the page it redirects to (which will fail to load): \n%v\n", authURL)
+	var redirected string
+	if _, err := fmt.Scan(&redirected); err != nil {
+		return "", fmt.Errorf("failed scanning Google OAuth redirect: %v", err)
+	}
+	u, err := url.Parse(redirected)
+	if err != nil {
+		return "", fmt.Errorf("failed parsing Google OAuth redirect: %v", err)
+	}
+	return getDriveAuthCodeFromQuery(u.Query(), state)
+}
+
+func getDriveAuthCodeFromQuery(q url.Values, state string) (string, error) {
+	if e := q.Get("error"); e != "" {
+		return "", fmt.Errorf("Google OAuth authorization failed: %s", e)
+	}
+	if subtle.ConstantTimeCompare([]byte(q.Get("state")), []byte(state)) != 1 {
+		return "", errors.New("Google OAuth redirect state mismatch")
+	}
+	authCode := q.Get("code")
+	if authCode == "" {
+		return "", errors.New("Google OAuth redirect missing auth code")
+	}
+	return authCode, nil
+}
+
+func randomDriveAuthState() (string, error) {
+	state := make([]byte, 16)
+	if _, err := rand.Read(state); err != nil {
+		return "", fmt.Errorf("failed generating Google OAuth state: %v", err)
+	}
+	return base64.RawURLEncoding.EncodeToString(state), nil
+}
+
 func saveDriveTokenToFile(tok *oauth2.Token) error {
 	name, err := getDriveTokenFileName()
 	if err != nil {
 		return fmt.Errorf("failed getting Google OAuth token file name for write: %v", err)
 	}
-
-	f, err := os.OpenFile(name, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0600)
+	// Encoding, encrypted when there's a keyring to keep the key in
+	bytes, err := json.Marshal(tok)
 	if err != nil {
-		return fmt.Errorf("failed opening Google OAuth token file for write: %v", err)
+		return fmt.Errorf("failed encoding Google OAuth token: %v", err)
 	}
-	defer f.Close()
+	if tokenKeyring != nil {
+		encrypted, err := encryptDriveToken(bytes)
+		if err != nil {
+			// E.g. headless without a D-Bus session, the file is still only readable by the user
+			warn("saving Google OAuth token unencrypted, as the keyring is unusable: %v", err)
+		} else {
+			bytes = encrypted
+		}
+	}
+	return writeDriveTokenFile(name, bytes)
+}
 
-	err = json.NewEncoder(f).Encode(tok)
+// encryptDriveToken returns the token file content with the token encrypted with the keyring's key.
+func encryptDriveToken(tok []byte) ([]byte, error) {
+	key, err := getDriveTokenKey()
+	if err != nil {
+		return nil, err
+	}
+	c, err := encryption.EncryptContent(key, tok)
+	if err != nil {
+		return nil, fmt.Errorf("failed encrypting Google OAuth token: %v", err)
+	}
+	bytes, err := json.Marshal(driveTokenFile{Encrypted: &c})
 	if err != nil {
-		return fmt.Errorf("failed encoding Google OAuth token file: %v", err)
+		return nil, fmt.Errorf("failed encoding Google OAuth token file: %v", err)
+	}
+	return bytes, nil
+}
+
+func writeDriveTokenFile(name string, bytes []byte) error {
+	if err := os.MkdirAll(path.Dir(name), 0700); err != nil {
+		return fmt.Errorf("failed creating Google OAuth token file dir: %v", err)
+	}
+	if err := os.WriteFile(name, bytes, 0600); err != nil {
+		return fmt.Errorf("failed writing Google OAuth token file: %v", err)
 	}
 	return nil
 }
@@ -237,3 +865,28 @@ func getDriveTokenFileName() (string, error) {
 	name := path.Join(homeDir, googleTokenFilePath)
 	return name, nil
 }
+
+// headless returns whether there's no local browser to authorize in.
+func headless() bool {
+	if os.Getenv("SSH_CONNECTION") != "" || os.Getenv("SSH_TTY") != "" {
+		return true
+	}
+	return runtime.GOOS == "linux" && os.Getenv("DISPLAY") == "" && os.Getenv("WAYLAND_DISPLAY") == ""
+}
+
+func openBrowser(url string) error {
+	switch runtime.GOOS {
+	case "linux":
+		return exec.Command("xdg-open", url).Start()
+	case "darwin":
+		return exec.Command("open", url).Start()
+	case "windows":
+		return exec.Command("rundll32", "url.dll,FileProtocolHandler", url).Start()
+	default:
+		return fmt.Errorf("don't know how to open browser for: %s", runtime.GOOS)
+	}
+}
+
+func driveQueryEscape(value string) string {
+	return strings.NewReplacer(`\`, `\\`, `'`, `\'`).Replace(value)
+}
@@ -0,0 +1,159 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/oauth2"
+)
+
+var googleRevokeURL = "https://oauth2.googleapis.com/revoke"
+var errDriveAuthRevoked = errors.New("Google Drive authorization was revoked or expired")
+
+// DriveAuth describes the saved Google Drive authorization.
+type DriveAuth struct {
+	LoggedIn bool
+	// Expiry of the access token, which is refreshed automatically.
+	Expiry time.Time
+	// Err is why the authorization can't be used, e.g. it was revoked.
+	Err error
+}
+
+// DriveAuthStatus checks the saved authorization, refreshing it to make sure it wasn't revoked.
+func DriveAuthStatus() (DriveAuth, error) {
+	s, err := configuredDriveStorage()
+	if err != nil {
+		return DriveAuth{}, err
+	}
+	oauthConfig, err := getDriveOauthConfig(s.config)
+	if err != nil {
+		return DriveAuth{}, err
+	}
+	tok, err := getDriveTokenFromFile()
+	if errors.Is(err, os.ErrNotExist) {
+		return DriveAuth{LoggedIn: false}, nil
+	} else if err != nil {
+		return DriveAuth{}, err
+	}
+	// Forcing a refresh
+	expired := *tok
+	expired.Expiry = time.Now()
+	ts := &driveTokenSource{oauthConfig: oauthConfig}
+	ts.reset(&expired)
+	tok, err = ts.Token()
+	if err != nil {
+		return DriveAuth{LoggedIn: true, Err: err}, nil
+	}
+	return DriveAuth{LoggedIn: true, Expiry: tok.Expiry}, nil
+}
+
+// DriveLogin interactively authorizes Drive access, replacing the saved authorization.
+func DriveLogin() error {
+	s, err := configuredDriveStorage()
+	if err != nil {
+		return err
+	}
+	oauthConfig, err := getDriveOauthConfig(s.config)
+	if err != nil {
+		return err
+	}
+	if _, err := authorizeDrive(s.config, oauthConfig); err != nil {
+		return fmt.Errorf("failed authorizing drive: %v", err)
+	}
+	s.srv = nil // Prepare again with the new authorization.
+	return nil
+}
+
+// DriveLogout revokes the saved authorization and deletes it.
+func DriveLogout() error {
+	tok, err := getDriveTokenFromFile()
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	// Revoking, best effort as the token file is deleted anyway
+	if tok != nil {
+		revokeDriveToken(tok)
+	}
+	// Deleting
+	name, err := getDriveTokenFileName()
+	if err != nil {
+		return fmt.Errorf("failed getting Google OAuth token file name for delete: %v", err)
+	}
+	if err := os.Remove(name); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("failed deleting Google OAuth token file: %v", err)
+	}
+	if tokenKeyring != nil {
+		if err := tokenKeyring.delete(googleTokenKeyringAccount); err != nil {
+			warn("failed deleting Google OAuth token key: %v", err)
+		}
+	}
+	return nil
+}
+
+func revokeDriveToken(tok *oauth2.Token) {
+	token := tok.RefreshToken
+	if token == "" {
+		token = tok.AccessToken
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), operationTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, googleRevokeURL, strings.NewReader(url.Values{"token": {token}}.Encode()))
+	if err != nil {
+		warn("failed creating Google OAuth token revoke request: %v", err)
+		return
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	r, err := http.DefaultClient.Do(req)
+	if err != nil {
+		warn("failed revoking Google OAuth token: %v", err)
+		return
+	}
+	r.Body.Close()
+	if r.StatusCode != http.StatusOK {
+		warn("failed revoking Google OAuth token: %s", r.Status)
+	}
+}
+
+// driveTokenSource saves refreshed tokens. It never authorizes again as it's used during requests,
+// failing with errDriveAuthRevoked instead.
+type driveTokenSource struct {
+	oauthConfig *oauth2.Config
+
+	mu    sync.Mutex
+	base  oauth2.TokenSource
+	saved *oauth2.Token
+}
+
+func (ts *driveTokenSource) Token() (*oauth2.Token, error) {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+
+	tok, err := ts.base.Token()
+	var retrieveErr *oauth2.RetrieveError
+	if errors.As(err, &retrieveErr) && retrieveErr.ErrorCode == "invalid_grant" {
+		return nil, fmt.Errorf("%w: %v", errDriveAuthRevoked, err)
+	} else if err != nil {
+		return nil, err
+	}
+
+	if tok.AccessToken != ts.saved.AccessToken {
+		// Refreshed
+		if err := saveDriveTokenToFile(tok); err != nil {
+			warn("failed saving refreshed Google OAuth token: %v", err)
+		}
+		ts.saved = tok
+	}
+	return tok, nil
+}
+
+func (ts *driveTokenSource) reset(tok *oauth2.Token) {
+	ts.base = ts.oauthConfig.TokenSource(context.Background(), tok)
+	ts.saved = tok
+}
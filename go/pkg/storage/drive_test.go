@@ -0,0 +1,482 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/odedniv/osafe/go/pkg/config"
+	"github.com/odedniv/osafe/go/pkg/drivefake"
+	"golang.org/x/oauth2"
+)
+
+func newDriveStorage(srv *drivefake.Server, c config.Drive) *driveStorage {
+	return &driveStorage{config: c, endpoint: srv.Endpoint(), client: srv.Client()}
+}
+
+// useStorages replaces the configured storages for the test, with a new HOME for local state.
+func useStorages(t *testing.T, ss ...storage) {
+	t.Setenv("HOME", t.TempDir())
+	storages, offline, readStorages = ss, false, map[storage]bool{}
+	t.Cleanup(func() { storages, offline, readStorages = nil, false, map[storage]bool{} })
+}
+
+func TestDriveReadWrite(t *testing.T) {
+	srv := drivefake.NewServer()
+	defer srv.Close()
+	ctx := context.Background()
+
+	s := newDriveStorage(srv, config.Drive{})
+	if c, err := s.read(ctx); err != nil || c.bytes != nil {
+		t.Fatalf("read() = %q, %v, want empty", c.bytes, err)
+	}
+	modifiedTime := time.Now().Truncate(time.Second)
+	if err := s.write(ctx, content{[]byte("first"), modifiedTime}); err != nil {
+		t.Fatalf("write() error = %v", err)
+	}
+	if err := s.write(ctx, content{[]byte("second"), modifiedTime.Add(time.Second)}); err != nil {
+		t.Fatalf("second write() error = %v", err)
+	}
+
+	files := srv.Files()
+	if len(files) != 1 || files[0].Name != "osafe.json" || files[0].Parents[0] != "root" || files[0].Version != 2 {
+		t.Fatalf("Files() = %+v, want osafe.json in root at version 2", files)
+	}
+	c, err := newDriveStorage(srv, config.Drive{}).read(ctx)
+	if err != nil || !bytes.Equal(c.bytes, []byte("second")) || !c.modifiedTime.Equal(modifiedTime.Add(time.Second)) {
+		t.Errorf("read() = %q at %v, %v, want %q at %v", c.bytes, c.modifiedTime, err, "second", modifiedTime.Add(time.Second))
+	}
+}
+
+func TestDriveConflict(t *testing.T) {
+	srv := drivefake.NewServer()
+	defer srv.Close()
+	ctx := context.Background()
+	srv.Put(drivefake.File{Name: "osafe.json", Parents: []string{"root"}, ModifiedTime: time.Now(), Content: []byte("base")})
+
+	s1 := newDriveStorage(srv, config.Drive{})
+	s2 := newDriveStorage(srv, config.Drive{})
+	for _, s := range []*driveStorage{s1, s2} {
+		if _, err := s.read(ctx); err != nil {
+			t.Fatalf("read() error = %v", err)
+		}
+	}
+	if err := s1.write(ctx, content{[]byte("first"), time.Now()}); err != nil {
+		t.Fatalf("write() error = %v", err)
+	}
+	if err := s2.write(ctx, content{[]byte("second"), time.Now()}); !errors.Is(err, ErrConflict) {
+		t.Errorf("write() error = %v, want ErrConflict", err)
+	}
+}
+
+func TestDriveFolderPath(t *testing.T) {
+	srv := drivefake.NewServer()
+	defer srv.Close()
+	ctx := context.Background()
+	c := config.Drive{FolderPath: "Secrets/OSafe", Filename: "vault.json"}
+
+	s := newDriveStorage(srv, c)
+	if got, err := s.read(ctx); err != nil || got.bytes != nil {
+		t.Fatalf("read() = %q, %v, want empty", got.bytes, err)
+	}
+	if files := srv.Files(); len(files) != 0 {
+		t.Fatalf("read() created files: %+v", files)
+	}
+	if err := s.write(ctx, content{[]byte("vault"), time.Now()}); err != nil {
+		t.Fatalf("write() error = %v", err)
+	}
+
+	byName := map[string]drivefake.File{}
+	for _, f := range srv.Files() {
+		byName[f.Name] = f
+	}
+	if len(byName) != 3 ||
+		byName["Secrets"].Parents[0] != "root" ||
+		byName["OSafe"].Parents[0] != byName["Secrets"].Id ||
+		byName["vault.json"].Parents[0] != byName["OSafe"].Id {
+		t.Fatalf("Files() = %+v, want vault.json in Secrets/OSafe", byName)
+	}
+	got, err := newDriveStorage(srv, c).read(ctx)
+	if err != nil || !bytes.Equal(got.bytes, []byte("vault")) {
+		t.Errorf("read() = %q, %v, want %q", got.bytes, err, "vault")
+	}
+}
+
+func TestDriveDuplicates(t *testing.T) {
+	srv := drivefake.NewServer()
+	defer srv.Close()
+	now := time.Now().Truncate(time.Second)
+	older := srv.Put(drivefake.File{Name: "osafe.json", Parents: []string{"root"}, ModifiedTime: now.Add(-time.Hour), Content: []byte(`{"revision":1}`)})
+	newer := srv.Put(drivefake.File{Name: "osafe.json", Parents: []string{"root"}, ModifiedTime: now, Content: []byte(`{"revision":2}`)})
+	s := newDriveStorage(srv, config.Drive{})
+	useStorages(t, s)
+
+	c, err := s.read(context.Background())
+	if err != nil || !bytes.Equal(c.bytes, newer.Content) {
+		t.Fatalf("read() = %q, %v, want the newest %q", c.bytes, err, newer.Content)
+	}
+	ds, err := DriveDuplicates()
+	if err != nil {
+		t.Fatalf("DriveDuplicates() error = %v", err)
+	}
+	if len(ds) != 2 || ds[0].Id != newer.Id || ds[1].Id != older.Id || ds[1].Message.Revision != 1 {
+		t.Fatalf("DriveDuplicates() = %+v, want %s then %s", ds, newer.Id, older.Id)
+	}
+	if err := DriveTrash([]string{older.Id}); err != nil {
+		t.Fatalf("DriveTrash() error = %v", err)
+	}
+	if ds, err := DriveDuplicates(); err != nil || len(ds) != 1 {
+		t.Errorf("DriveDuplicates() = %+v, %v, want only %s", ds, err, newer.Id)
+	}
+}
+
+func TestDriveSyncToOlder(t *testing.T) {
+	srv := drivefake.NewServer()
+	defer srv.Close()
+	now := time.Now().Truncate(time.Second)
+	old := []byte(`{"revision":1}`)
+	latest := []byte(fmt.Sprintf(`{"revision":2,"parent":"%s"}`, contentHash(old)))
+	// The older revision has the newer modified time, revisions take precedence
+	srv.Put(drivefake.File{Name: "a.json", Parents: []string{"root"}, ModifiedTime: now.Add(-time.Hour), Content: latest})
+	srv.Put(drivefake.File{Name: "b.json", Parents: []string{"root"}, ModifiedTime: now, Content: old})
+	useStorages(t,
+		newDriveStorage(srv, config.Drive{Filename: "a.json"}),
+		newDriveStorage(srv, config.Drive{Filename: "b.json"}),
+	)
+
+	c, err := syncAll()
+	if err != nil || !bytes.Equal(c.bytes, latest) {
+		t.Fatalf("syncAll() = %q, %v, want %q", c.bytes, err, latest)
+	}
+	for _, f := range srv.Files() {
+		if !bytes.Equal(f.Content, latest) || !f.ModifiedTime.Equal(now.Add(-time.Hour)) {
+			t.Errorf("%s = %q at %v, want %q at %v", f.Name, f.Content, f.ModifiedTime, latest, now.Add(-time.Hour))
+		}
+	}
+}
+
+func TestDriveRetry(t *testing.T) {
+	srv := drivefake.NewServer()
+	defer srv.Close()
+	defer func(d time.Duration) { retryBaseDelay = d }(retryBaseDelay)
+	retryBaseDelay = time.Millisecond
+	srv.Put(drivefake.File{Name: "osafe.json", Parents: []string{"root"}, ModifiedTime: time.Now(), Content: []byte("vault")})
+	s := newDriveStorage(srv, config.Drive{})
+	s.client = &http.Client{Transport: &retryTransport{base: srv.Client().Transport}}
+
+	srv.Fail(http.StatusServiceUnavailable, http.StatusServiceUnavailable)
+	c, err := s.read(context.Background())
+	if err != nil || !bytes.Equal(c.bytes, []byte("vault")) {
+		t.Fatalf("read() = %q, %v, want %q", c.bytes, err, "vault")
+	}
+	if got := srv.Requests(); got != 4 { // Failed list twice, list and download.
+		t.Errorf("Requests() = %d, want 4", got)
+	}
+}
+
+func TestDriveError(t *testing.T) {
+	srv := drivefake.NewServer()
+	defer srv.Close()
+	s := newDriveStorage(srv, config.Drive{})
+	s.client = &http.Client{Transport: &retryTransport{base: srv.Client().Transport}}
+
+	srv.Fail(http.StatusForbidden)
+	if _, err := s.read(context.Background()); err == nil {
+		t.Fatal("read() error = nil, want an error")
+	}
+	if got := srv.Requests(); got != 1 {
+		t.Errorf("Requests() = %d, want 1 as 403 isn't retried", got)
+	}
+	if err := newDriveStorage(srv, config.Drive{AppData: true, FolderId: "folder"}).write(context.Background(), content{}); err == nil {
+		t.Error("write() with folderId and appData error = nil, want an error")
+	}
+}
+
+func TestDriveRevisions(t *testing.T) {
+	srv := drivefake.NewServer()
+	defer srv.Close()
+	s := newDriveStorage(srv, config.Drive{})
+	useStorages(t, s)
+	ctx := context.Background()
+	now := time.Now().Truncate(time.Second)
+	if _, err := s.read(ctx); err != nil {
+		t.Fatalf("read() error = %v", err)
+	}
+	for i, c := range []string{`{"revision":1}`, `{"revision":2}`} {
+		if err := s.write(ctx, content{[]byte(c), now.Add(time.Duration(i) * time.Second)}); err != nil {
+			t.Fatalf("write() error = %v", err)
+		}
+	}
+
+	revisions, err := DriveRevisions()
+	if err != nil {
+		t.Fatalf("DriveRevisions() error = %v", err)
+	}
+	if len(revisions) != 2 || !revisions[0].ModifiedTime.Equal(now) || revisions[1].Size != int64(len(`{"revision":2}`)) {
+		t.Fatalf("DriveRevisions() = %+v, want 2 revisions oldest first", revisions)
+	}
+	m, err := ReadDriveRevision(revisions[0].Id)
+	if err != nil || m.Revision != 1 {
+		t.Errorf("ReadDriveRevision() = %+v, %v, want revision 1", m, err)
+	}
+	if _, err := ReadDriveRevision("missing"); err == nil {
+		t.Error("ReadDriveRevision() of a missing revision error = nil, want an error")
+	}
+}
+
+func TestDriveRevisionsMissingFile(t *testing.T) {
+	srv := drivefake.NewServer()
+	defer srv.Close()
+	useStorages(t, newDriveStorage(srv, config.Drive{}))
+	if _, err := DriveRevisions(); err == nil {
+		t.Error("DriveRevisions() without a vault file error = nil, want an error")
+	}
+}
+
+// newDeviceAuthServer fakes the Google OAuth device flow, answering token polls with the given
+// errors in order and then with a token. Returns the config to use and the count of polls.
+func newDeviceAuthServer(t *testing.T, pollErrors ...string) (*oauth2.Config, func() int) {
+	var mu sync.Mutex
+	polls := 0
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /device/code", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]any{
+			"device_code":      "device-code",
+			"user_code":        "USER-CODE",
+			"verification_url": "https://example.com/device",
+			"expires_in":       60,
+			"interval":         1,
+		})
+	})
+	mux.HandleFunc("POST /token", func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		polls++
+		w.Header().Set("Content-Type", "application/json")
+		if r.FormValue("device_code") != "device-code" {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]any{"error": "invalid_grant"})
+			return
+		}
+		if polls <= len(pollErrors) {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]any{"error": pollErrors[polls-1]})
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]any{
+			"access_token":  "access-token",
+			"refresh_token": "refresh-token",
+			"token_type":    "Bearer",
+			"expires_in":    3600,
+		})
+	})
+	srv := httptest.NewServer(mux)
+	t.Cleanup(srv.Close)
+	// Saving the token without a keyring
+	t.Setenv("HOME", t.TempDir())
+	k := tokenKeyring
+	t.Cleanup(func() { tokenKeyring = k })
+	tokenKeyring = nil
+
+	c := &oauth2.Config{
+		ClientID: "client-id",
+		Endpoint: oauth2.Endpoint{
+			DeviceAuthURL: srv.URL + "/device/code",
+			TokenURL:      srv.URL + "/token",
+			AuthStyle:     oauth2.AuthStyleInParams,
+		},
+	}
+	return c, func() int {
+		mu.Lock()
+		defer mu.Unlock()
+		return polls
+	}
+}
+
+func TestDriveDeviceAuth(t *testing.T) {
+	c, polls := newDeviceAuthServer(t, "authorization_pending", "authorization_pending")
+	tok, err := getDriveTokenFromDevice(c)
+	if err != nil || tok.AccessToken != "access-token" {
+		t.Fatalf("getDriveTokenFromDevice() = %+v, %v, want access-token", tok, err)
+	}
+	if got := polls(); got != 3 {
+		t.Errorf("polls = %d, want 3", got)
+	}
+	if saved, err := getDriveTokenFromFile(); err != nil || saved.RefreshToken != "refresh-token" {
+		t.Errorf("getDriveTokenFromFile() = %+v, %v, want the saved token", saved, err)
+	}
+}
+
+func TestDriveDeviceAuthSlowDown(t *testing.T) {
+	if testing.Short() {
+		t.Skip("slowing down polls to every 6 seconds")
+	}
+	c, polls := newDeviceAuthServer(t, "slow_down")
+	start := time.Now()
+	if _, err := getDriveTokenFromDevice(c); err != nil {
+		t.Fatalf("getDriveTokenFromDevice() error = %v", err)
+	}
+	if elapsed := time.Since(start); elapsed < time.Second*7 {
+		t.Errorf("getDriveTokenFromDevice() took %v, want polls slowed down to 6s", elapsed)
+	}
+	if got := polls(); got != 2 {
+		t.Errorf("polls = %d, want 2", got)
+	}
+}
+
+func TestDriveDeviceAuthExpired(t *testing.T) {
+	c, _ := newDeviceAuthServer(t, "authorization_pending", "expired_token")
+	if _, err := getDriveTokenFromDevice(c); err == nil {
+		t.Error("getDriveTokenFromDevice() error = nil, want an error")
+	}
+	if _, err := getDriveTokenFromFile(); err == nil {
+		t.Error("getDriveTokenFromFile() error = nil, want no token saved")
+	}
+}
+
+func TestDriveDeviceAuthTimeout(t *testing.T) {
+	defer func(d time.Duration) { driveAuthTimeout = d }(driveAuthTimeout)
+	driveAuthTimeout = time.Millisecond * 1500
+	pending := make([]string, 10)
+	for i := range pending {
+		pending[i] = "authorization_pending"
+	}
+	c, _ := newDeviceAuthServer(t, pending...)
+	start := time.Now()
+	if _, err := getDriveTokenFromDevice(c); err == nil {
+		t.Error("getDriveTokenFromDevice() error = nil, want a timeout")
+	}
+	if elapsed := time.Since(start); elapsed > time.Second*3 {
+		t.Errorf("getDriveTokenFromDevice() took %v, want it to stop after %v", elapsed, driveAuthTimeout)
+	}
+}
+
+func TestDriveTokenSourceRevoked(t *testing.T) {
+	c, polls := newDeviceAuthServer(t)
+	ts := &driveTokenSource{oauthConfig: c}
+	ts.reset(&oauth2.Token{AccessToken: "expired", RefreshToken: "revoked", Expiry: time.Now()})
+	// Not authorizing again during a request
+	if _, err := ts.Token(); !errors.Is(err, errDriveAuthRevoked) {
+		t.Errorf("Token() error = %v, want errDriveAuthRevoked", err)
+	}
+	if polls() != 1 {
+		t.Errorf("token requests = %d, want only the refresh", polls())
+	}
+}
+
+func TestDriveAppData(t *testing.T) {
+	srv := drivefake.NewServer()
+	defer srv.Close()
+	ctx := context.Background()
+	c := config.Drive{AppData: true, FolderPath: "Secrets/OSafe"}
+	if err := newDriveStorage(srv, c).write(ctx, content{[]byte("hidden"), time.Now()}); err != nil {
+		t.Fatalf("write() error = %v", err)
+	}
+
+	// Only in the app data folder
+	files := map[string]drivefake.File{}
+	for _, f := range srv.Files() {
+		files[f.Name] = f
+	}
+	if files["Secrets"].Parents[0] != "appDataFolder" || files["OSafe"].Parents[0] != files["Secrets"].Id ||
+		files["osafe.json"].Parents[0] != files["OSafe"].Id {
+		t.Errorf("Files() = %+v, want Secrets/OSafe/osafe.json in appDataFolder", srv.Files())
+	}
+	if got, err := newDriveStorage(srv, c).read(ctx); err != nil || !bytes.Equal(got.bytes, []byte("hidden")) {
+		t.Errorf("read() = %q, %v, want %q", got.bytes, err, "hidden")
+	}
+	myDrive := config.Drive{FolderPath: "Secrets/OSafe"}
+	if got, err := newDriveStorage(srv, myDrive).read(ctx); err != nil || got.bytes != nil {
+		t.Errorf("read() from My Drive = %q, %v, want empty", got.bytes, err)
+	}
+	// With a folder ID
+	if err := newDriveStorage(srv, config.Drive{AppData: true, FolderId: "folder"}).write(ctx, content{[]byte("x"), time.Now()}); err == nil {
+		t.Error("write() with folderId and appData error = nil, want an error")
+	}
+}
+
+func TestDriveMigrate(t *testing.T) {
+	tests := []struct {
+		name       string
+		source     []byte
+		target     []byte
+		from       config.Drive
+		wantErr    bool
+		wantTarget []byte
+	}{
+		{name: "to app data", source: []byte("vault"), wantTarget: []byte("vault")},
+		{name: "same in target", source: []byte("vault"), target: []byte("vault"), wantTarget: []byte("vault")},
+		{name: "different in target", source: []byte("vault"), target: []byte("other"), wantErr: true, wantTarget: []byte("other")},
+		{name: "no source", wantErr: true},
+		{name: "same location", source: []byte("vault"), from: config.Drive{AppData: true}, wantErr: true, wantTarget: []byte("vault")},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := drivefake.NewServer()
+			defer srv.Close()
+			var source drivefake.File
+			if tt.source != nil {
+				parent := "root"
+				if tt.from.AppData {
+					parent = "appDataFolder"
+				}
+				source = srv.Put(drivefake.File{Name: "osafe.json", Parents: []string{parent}, Content: tt.source, ModifiedTime: time.Now()})
+			}
+			if tt.target != nil {
+				srv.Put(drivefake.File{Name: "osafe.json", Parents: []string{"appDataFolder"}, Content: tt.target, ModifiedTime: time.Now()})
+			}
+			useStorages(t, newDriveStorage(srv, config.Drive{AppData: true}))
+
+			err := DriveMigrate(tt.from)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("DriveMigrate() error = %v, want error: %v", err, tt.wantErr)
+			}
+			got, err := newDriveStorage(srv, config.Drive{AppData: true}).read(context.Background())
+			if err != nil || !bytes.Equal(got.bytes, tt.wantTarget) {
+				t.Errorf("target read() = %q, %v, want %q", got.bytes, err, tt.wantTarget)
+			}
+			if tt.source == nil {
+				return
+			}
+			for _, f := range srv.Files() {
+				if f.Id == source.Id && f.Trashed == tt.wantErr {
+					t.Errorf("source trashed = %v, want %v", f.Trashed, !tt.wantErr)
+				}
+			}
+		})
+	}
+}
+
+func TestRevokeDriveToken(t *testing.T) {
+	defer func(d time.Duration, u string) { operationTimeout, googleRevokeURL = d, u }(operationTimeout, googleRevokeURL)
+	operationTimeout = time.Millisecond * 100
+	revoked := make(chan string, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.FormValue("token") == "hanging" {
+			<-r.Context().Done()
+			return
+		}
+		revoked <- r.FormValue("token")
+	}))
+	defer srv.Close()
+	googleRevokeURL = srv.URL
+
+	revokeDriveToken(&oauth2.Token{AccessToken: "access-token", RefreshToken: "refresh-token"})
+	if got := <-revoked; got != "refresh-token" {
+		t.Errorf("revoked token = %q, want the refresh token", got)
+	}
+	// Not waiting for an unresponsive server
+	start := time.Now()
+	revokeDriveToken(&oauth2.Token{RefreshToken: "hanging"})
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("revokeDriveToken() took %v, want it to time out", elapsed)
+	}
+}
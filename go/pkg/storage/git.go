@@ -0,0 +1,195 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/odedniv/osafe/go/pkg/config"
+)
+
+var gitCommitMessage = "Update " + storageFilename
+
+type gitStorage struct {
+	config config.Git
+	dir    string
+	head   string // Empty before the first commit.
+	known  bool
+}
+
+func (s *gitStorage) name() string {
+	return "git"
+}
+
+func (s *gitStorage) read(ctx context.Context) (content, error) {
+	if err := s.prepare(ctx); err != nil {
+		return content{}, fmt.Errorf("failed preparing git storage for read: %v", err)
+	}
+	// Pulling
+	if s.config.Remote != "" {
+		if err := s.pull(ctx); err != nil {
+			return content{}, fmt.Errorf("failed pulling git storage: %w", err)
+		}
+	}
+	head, err := s.revParseHead(ctx)
+	if err != nil {
+		return content{}, fmt.Errorf("failed getting git storage HEAD for read: %v", err)
+	}
+	// Reading
+	bytes, err := os.ReadFile(path.Join(s.dir, storageFilename))
+	if os.IsNotExist(err) {
+		s.head, s.known = head, true
+		return content{}, nil
+	} else if err != nil {
+		return content{}, fmt.Errorf("failed reading from git storage: %v", err)
+	}
+	// Modified time
+	out, err := s.git(ctx, "log", "-1", "--format=%cI", "--", storageFilename)
+	if err != nil {
+		return content{}, fmt.Errorf("failed getting git storage commit time: %v", err)
+	}
+	var modifiedTime time.Time
+	if out != "" {
+		modifiedTime, err = time.Parse(time.RFC3339, out)
+		if err != nil {
+			return content{}, fmt.Errorf("failed parsing git storage commit time: %v", err)
+		}
+	} // Otherwise not committed yet, so older than anything else.
+	s.head, s.known = head, true
+	return content{bytes, modifiedTime}, nil
+}
+
+func (s *gitStorage) write(ctx context.Context, c content) error {
+	if err := s.prepare(ctx); err != nil {
+		return fmt.Errorf("failed preparing git storage for write: %v", err)
+	}
+	// Pulling, making sure we're not overwriting a change that was pushed since read
+	if s.config.Remote != "" {
+		if err := s.pull(ctx); err != nil {
+			return fmt.Errorf("failed pulling git storage for write: %w", err)
+		}
+	}
+	head, err := s.revParseHead(ctx)
+	if err != nil {
+		return fmt.Errorf("failed getting git storage HEAD for write: %v", err)
+	}
+	if s.known && head != s.head {
+		return fmt.Errorf("git storage was committed to since it was read: %w", ErrConflict)
+	}
+	// Committing
+	err = os.WriteFile(path.Join(s.dir, storageFilename), c.bytes, 0600)
+	if err != nil {
+		return fmt.Errorf("failed writing to git storage: %v", err)
+	}
+	if _, err := s.git(ctx, "add", "--", storageFilename); err != nil {
+		return fmt.Errorf("failed adding to git storage: %v", err)
+	}
+	if status, err := s.git(ctx, "status", "--porcelain", "--", storageFilename); err != nil {
+		return fmt.Errorf("failed getting git storage status: %v", err)
+	} else if status != "" {
+		if err := s.commit(ctx, c.modifiedTime); err != nil {
+			return err
+		}
+	}
+	// Pushing
+	if s.config.Remote != "" {
+		if _, err := s.git(ctx, "push", "--quiet", s.config.Remote, "HEAD:"+s.config.Branch); err != nil {
+			if strings.Contains(err.Error(), "[rejected]") {
+				return fmt.Errorf("git storage remote was pushed to since it was read (%v): %w", err, ErrConflict)
+			}
+			return fmt.Errorf("failed pushing git storage: %v", err)
+		}
+	}
+	s.head, err = s.revParseHead(ctx)
+	if err != nil {
+		s.known = false
+		return fmt.Errorf("failed getting git storage HEAD after write: %v", err)
+	}
+	s.known = true
+	return nil
+}
+
+func (s *gitStorage) commit(ctx context.Context, modifiedTime time.Time) error {
+	cmd := s.command(ctx, "commit", "--quiet", "--message", gitCommitMessage, "--", storageFilename)
+	// Commit time is the storage's modified time, so use the content's
+	date := modifiedTime.Format(time.RFC3339)
+	cmd.Env = append(os.Environ(), "GIT_AUTHOR_DATE="+date, "GIT_COMMITTER_DATE="+date)
+	if _, err := runCommand(cmd); err != nil {
+		return fmt.Errorf("failed committing to git storage: %v", err)
+	}
+	return nil
+}
+
+func (s *gitStorage) pull(ctx context.Context) error {
+	if _, err := s.git(ctx, "fetch", "--quiet", s.config.Remote, s.config.Branch); err != nil {
+		// Empty remotes have nothing to pull, pushing will create the branch. ls-remote exits with 2
+		// when the branch doesn't exist, other failures are the remote's.
+		_, lsErr := s.git(ctx, "ls-remote", "--exit-code", "--heads", s.config.Remote, s.config.Branch)
+		var exitErr *exec.ExitError
+		if errors.As(lsErr, &exitErr) && exitErr.ExitCode() == 2 {
+			return nil
+		}
+		return fmt.Errorf("failed fetching: %v", err)
+	}
+	if _, err := s.git(ctx, "merge", "--quiet", "--ff-only", "FETCH_HEAD"); err != nil {
+		return fmt.Errorf("local and remote git storage diverged (%v): %w", err, ErrConflict)
+	}
+	return nil
+}
+
+// revParseHead returns an empty string before the first commit.
+func (s *gitStorage) revParseHead(ctx context.Context) (string, error) {
+	head, err := s.git(ctx, "rev-parse", "--verify", "--quiet", "HEAD")
+	if err != nil {
+		return "", nil
+	}
+	return head, nil
+}
+
+func (s *gitStorage) prepare(ctx context.Context) error {
+	if s.dir != "" {
+		return nil // Already prepared.
+	}
+	dir, err := userHomePath(s.config.Dir)
+	if err != nil {
+		return err
+	}
+	s.dir = dir
+	if _, err := s.git(ctx, "rev-parse", "--is-inside-work-tree"); err != nil {
+		s.dir = ""
+		return fmt.Errorf("not a git working copy %s: %v", dir, err)
+	}
+	if s.config.Remote != "" && s.config.Branch == "" {
+		branch, err := s.git(ctx, "symbolic-ref", "--short", "HEAD")
+		if err != nil {
+			s.dir = ""
+			return fmt.Errorf("failed getting git storage branch: %v", err)
+		}
+		s.config.Branch = branch
+	}
+	return nil
+}
+
+func (s *gitStorage) git(ctx context.Context, args ...string) (string, error) {
+	return runCommand(s.command(ctx, args...))
+}
+
+func (s *gitStorage) command(ctx context.Context, args ...string) *exec.Cmd {
+	return exec.CommandContext(ctx, "git", append([]string{"-C", s.dir}, args...)...)
+}
+
+func runCommand(cmd *exec.Cmd) (string, error) {
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return "", fmt.Errorf("%s: %w: %s", strings.Join(cmd.Args, " "), err, strings.TrimSpace(stderr.String()))
+	}
+	return strings.TrimSpace(stdout.String()), nil
+}
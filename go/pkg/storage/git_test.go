@@ -0,0 +1,82 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"os/exec"
+	"path"
+	"testing"
+	"time"
+
+	"github.com/odedniv/osafe/go/pkg/config"
+)
+
+func newGitWorkingCopy(t *testing.T, remote string) string {
+	t.Setenv("HOME", t.TempDir())
+	t.Setenv("GIT_AUTHOR_NAME", "test")
+	t.Setenv("GIT_AUTHOR_EMAIL", "test@example.com")
+	t.Setenv("GIT_COMMITTER_NAME", "test")
+	t.Setenv("GIT_COMMITTER_EMAIL", "test@example.com")
+	dir := t.TempDir()
+	runGit(t, dir, "init", "--quiet", "--initial-branch=main")
+	if remote != "" {
+		runGit(t, dir, "remote", "add", "origin", remote)
+	}
+	return dir
+}
+
+func runGit(t *testing.T, dir string, args ...string) {
+	if out, err := exec.Command("git", append([]string{"-C", dir}, args...)...).CombinedOutput(); err != nil {
+		t.Fatalf("git %v: %v: %s", args, err, out)
+	}
+}
+
+func TestGitEmptyRemote(t *testing.T) {
+	remote := t.TempDir()
+	runGit(t, remote, "init", "--quiet", "--bare")
+	ctx := context.Background()
+
+	s := &gitStorage{config: config.Git{Dir: newGitWorkingCopy(t, remote), Remote: "origin"}}
+	if c, err := s.read(ctx); err != nil || c.bytes != nil {
+		t.Fatalf("read() = %q, %v, want empty", c.bytes, err)
+	}
+	modifiedTime := time.Now().Truncate(time.Second)
+	if err := s.write(ctx, content{[]byte("first"), modifiedTime}); err != nil {
+		t.Fatalf("write() error = %v", err)
+	}
+
+	other := &gitStorage{config: config.Git{Dir: newGitWorkingCopy(t, remote), Remote: "origin", Branch: "main"}}
+	c, err := other.read(ctx)
+	if err != nil || !bytes.Equal(c.bytes, []byte("first")) || !c.modifiedTime.Equal(modifiedTime) {
+		t.Errorf("read() = %q at %v, %v, want %q at %v", c.bytes, c.modifiedTime, err, "first", modifiedTime)
+	}
+}
+
+func TestGitUnreachableRemote(t *testing.T) {
+	remote := path.Join(t.TempDir(), "missing")
+	s := &gitStorage{config: config.Git{Dir: newGitWorkingCopy(t, remote), Remote: "origin"}}
+	if _, err := s.read(context.Background()); err == nil {
+		t.Error("read() error = nil, want an error")
+	}
+}
+
+func TestGitConflict(t *testing.T) {
+	remote := t.TempDir()
+	runGit(t, remote, "init", "--quiet", "--bare")
+	ctx := context.Background()
+	s1 := &gitStorage{config: config.Git{Dir: newGitWorkingCopy(t, remote), Remote: "origin"}}
+	s2 := &gitStorage{config: config.Git{Dir: newGitWorkingCopy(t, remote), Remote: "origin", Branch: "main"}}
+	for _, s := range []*gitStorage{s1, s2} {
+		if _, err := s.read(ctx); err != nil {
+			t.Fatalf("read() error = %v", err)
+		}
+	}
+
+	if err := s1.write(ctx, content{[]byte("first"), time.Now()}); err != nil {
+		t.Fatalf("write() error = %v", err)
+	}
+	if err := s2.write(ctx, content{[]byte("second"), time.Now()}); !errors.Is(err, ErrConflict) {
+		t.Errorf("write() error = %v, want ErrConflict", err)
+	}
+}
@@ -0,0 +1,118 @@
+package storage
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"runtime"
+	"strings"
+)
+
+var keyringService = "osafe"
+var securityItemNotFound = 44 // errSecItemNotFound, as the exit code of security.
+
+// keyring stores small secrets in the OS keyring.
+type keyring interface {
+	// get returns an error wrapping os.ErrNotExist when there's no such secret.
+	get(account string) ([]byte, error)
+	set(account string, secret []byte) error
+	delete(account string) error
+}
+
+// systemKeyring returns the keyring of the OS, or nil when there's none available.
+func systemKeyring() keyring {
+	switch runtime.GOOS {
+	case "linux":
+		if _, err := exec.LookPath("secret-tool"); err == nil {
+			return secretToolKeyring{}
+		}
+	case "darwin":
+		if _, err := exec.LookPath("security"); err == nil {
+			return securityKeyring{}
+		}
+	}
+	return nil
+}
+
+// Linux Secret Service (GNOME Keyring, KWallet)
+
+type secretToolKeyring struct{}
+
+func (secretToolKeyring) get(account string) ([]byte, error) {
+	cmd := exec.Command("secret-tool", "lookup", "service", keyringService, "account", account)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	// Exits with 1 and no output when there's no such secret, also when the keyring is unusable but
+	// then with an error
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) && exitErr.ExitCode() == 1 && len(out) == 0 && stderr.Len() == 0 {
+		return nil, fmt.Errorf("no %s in keyring: %w", account, os.ErrNotExist)
+	} else if err != nil {
+		return nil, fmt.Errorf("failed looking up %s in keyring: %v: %s", account, err, strings.TrimSpace(stderr.String()))
+	}
+	return decodeKeyringSecret(strings.TrimSpace(string(out)))
+}
+
+func (secretToolKeyring) set(account string, secret []byte) error {
+	cmd := exec.Command("secret-tool", "store", "--label", "OSafe "+account, "service", keyringService, "account", account)
+	cmd.Stdin = strings.NewReader(base64.StdEncoding.EncodeToString(secret))
+	if _, err := runCommand(cmd); err != nil {
+		return fmt.Errorf("failed storing %s in keyring: %v", account, err)
+	}
+	return nil
+}
+
+func (secretToolKeyring) delete(account string) error {
+	if _, err := runCommand(exec.Command("secret-tool", "clear", "service", keyringService, "account", account)); err != nil {
+		return fmt.Errorf("failed deleting %s from keyring: %v", account, err)
+	}
+	return nil
+}
+
+// macOS Keychain
+
+type securityKeyring struct{}
+
+func (securityKeyring) get(account string) ([]byte, error) {
+	out, err := runCommand(exec.Command("security", "find-generic-password", "-s", keyringService, "-a", account, "-w"))
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) && exitErr.ExitCode() == securityItemNotFound {
+		return nil, fmt.Errorf("no %s in keychain: %w", account, os.ErrNotExist)
+	} else if err != nil {
+		return nil, fmt.Errorf("failed looking up %s in keychain: %v", account, err)
+	}
+	return decodeKeyringSecret(out)
+}
+
+func (securityKeyring) set(account string, secret []byte) error {
+	// -w without a value prompts for the secret and its confirmation, keeping it off the command line
+	// where other users can see it.
+	cmd := exec.Command("security", "add-generic-password", "-U", "-s", keyringService, "-a", account, "-w")
+	encoded := base64.StdEncoding.EncodeToString(secret)
+	cmd.Stdin = strings.NewReader(encoded + "\n" + encoded + "\n")
+	if _, err := runCommand(cmd); err != nil {
+		return fmt.Errorf("failed storing %s in keychain: %v", account, err)
+	}
+	return nil
+}
+
+func (securityKeyring) delete(account string) error {
+	if _, err := runCommand(exec.Command("security", "delete-generic-password", "-s", keyringService, "-a", account)); err != nil {
+		return fmt.Errorf("failed deleting %s from keychain: %v", account, err)
+	}
+	return nil
+}
+
+// Utilities
+
+func decodeKeyringSecret(encoded string) ([]byte, error) {
+	secret, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, fmt.Errorf("failed decoding keyring secret: %v", err)
+	}
+	return secret, nil
+}
@@ -0,0 +1,167 @@
+package storage
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"os"
+	"path"
+	"slices"
+	"strings"
+	"testing"
+
+	"golang.org/x/oauth2"
+)
+
+// fakeKeyring keeps secrets in memory, failing with err when it's set.
+type fakeKeyring struct {
+	secrets map[string][]byte
+	err     error
+}
+
+func (k *fakeKeyring) get(account string) ([]byte, error) {
+	if k.err != nil {
+		return nil, k.err
+	}
+	secret, ok := k.secrets[account]
+	if !ok {
+		return nil, os.ErrNotExist
+	}
+	return secret, nil
+}
+
+func (k *fakeKeyring) set(account string, secret []byte) error {
+	if k.err != nil {
+		return k.err
+	}
+	k.secrets[account] = secret
+	return nil
+}
+
+func (k *fakeKeyring) delete(account string) error {
+	if k.err != nil {
+		return k.err
+	}
+	delete(k.secrets, account)
+	return nil
+}
+
+// useTokenKeyring replaces the token keyring for the test, with a new HOME for the token file.
+func useTokenKeyring(t *testing.T, k keyring) {
+	t.Setenv("HOME", t.TempDir())
+	previous := tokenKeyring
+	t.Cleanup(func() { tokenKeyring = previous })
+	tokenKeyring = k
+}
+
+// fakeCommand puts a shell script named name first on PATH.
+func fakeCommand(t *testing.T, name string, script string) {
+	dir := t.TempDir()
+	if err := os.WriteFile(path.Join(dir, name), []byte("#!/bin/sh\n"+script), 0700); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("PATH", dir+string(os.PathListSeparator)+os.Getenv("PATH"))
+}
+
+func TestSecretToolKeyringGet(t *testing.T) {
+	secret := []byte("secret")
+	tests := []struct {
+		name     string
+		script   string
+		want     []byte
+		notExist bool
+	}{
+		{name: "found", script: "echo " + base64.StdEncoding.EncodeToString(secret), want: secret},
+		{name: "not found", script: "exit 1", notExist: true},
+		{name: "no D-Bus", script: "echo 'Cannot autolaunch D-Bus without X11 $DISPLAY' >&2\nexit 1"},
+		{name: "other failure", script: "exit 2"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fakeCommand(t, "secret-tool", tt.script)
+			got, err := secretToolKeyring{}.get("account")
+			if tt.want != nil {
+				if err != nil || !bytes.Equal(got, tt.want) {
+					t.Errorf("get() = %q, %v, want %q", got, err, tt.want)
+				}
+			} else if err == nil || errors.Is(err, os.ErrNotExist) != tt.notExist {
+				t.Errorf("get() error = %v, want os.ErrNotExist: %v", err, tt.notExist)
+			}
+		})
+	}
+}
+
+func TestSecurityKeyring(t *testing.T) {
+	dir := t.TempDir()
+	fakeCommand(t, "security", `
+case "$1" in
+find-generic-password) echo 'The specified item could not be found in the keychain.' >&2; exit 44;;
+add-generic-password) echo "$@" > `+dir+`/args; cat > `+dir+`/stdin;;
+esac`)
+
+	if _, err := (securityKeyring{}).get("account"); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("get() error = %v, want os.ErrNotExist", err)
+	}
+	if err := (securityKeyring{}).set("account", []byte("secret")); err != nil {
+		t.Fatalf("set() error = %v", err)
+	}
+	encoded := base64.StdEncoding.EncodeToString([]byte("secret"))
+	args, _ := os.ReadFile(path.Join(dir, "args"))
+	if fields := strings.Fields(string(args)); slices.Contains(fields, encoded) || fields[len(fields)-1] != "-w" {
+		t.Errorf("set() args = %q, want -w last without the secret", args)
+	}
+	if stdin, _ := os.ReadFile(path.Join(dir, "stdin")); string(stdin) != encoded+"\n"+encoded+"\n" {
+		t.Errorf("set() stdin = %q, want the secret and its confirmation", stdin)
+	}
+}
+
+func TestDriveTokenKeyringUnusable(t *testing.T) {
+	k := &fakeKeyring{secrets: map[string][]byte{googleTokenKeyringAccount: []byte("key")}, err: errors.New("no D-Bus")}
+	useTokenKeyring(t, k)
+
+	// Not replacing the existing key
+	if _, err := getDriveTokenKey(); err == nil {
+		t.Error("getDriveTokenKey() error = nil, want an error")
+	}
+	if !bytes.Equal(k.secrets[googleTokenKeyringAccount], []byte("key")) {
+		t.Errorf("getDriveTokenKey() replaced the key with %q", k.secrets[googleTokenKeyringAccount])
+	}
+	// Saving unencrypted
+	if err := saveDriveTokenToFile(&oauth2.Token{RefreshToken: "refresh-token"}); err != nil {
+		t.Fatalf("saveDriveTokenToFile() error = %v", err)
+	}
+	name, _ := getDriveTokenFileName()
+	info, err := os.Stat(name)
+	if err != nil || info.Mode().Perm() != 0600 {
+		t.Fatalf("token file = %v, %v, want mode 0600", info, err)
+	}
+	tok, err := getDriveTokenFromFile()
+	if err != nil || tok.RefreshToken != "refresh-token" {
+		t.Errorf("getDriveTokenFromFile() = %+v, %v, want refresh-token", tok, err)
+	}
+}
+
+func TestDriveTokenEncrypted(t *testing.T) {
+	k := &fakeKeyring{secrets: map[string][]byte{}}
+	useTokenKeyring(t, k)
+
+	if err := saveDriveTokenToFile(&oauth2.Token{RefreshToken: "refresh-token"}); err != nil {
+		t.Fatalf("saveDriveTokenToFile() error = %v", err)
+	}
+	name, _ := getDriveTokenFileName()
+	bytes, _ := os.ReadFile(name)
+	var file driveTokenFile
+	if err := json.Unmarshal(bytes, &file); err != nil || file.Encrypted == nil {
+		t.Fatalf("token file = %s, want it encrypted", bytes)
+	}
+	tok, err := getDriveTokenFromFile()
+	if err != nil || tok.RefreshToken != "refresh-token" {
+		t.Errorf("getDriveTokenFromFile() = %+v, %v, want refresh-token", tok, err)
+	}
+	// Lost key
+	delete(k.secrets, googleTokenKeyringAccount)
+	if _, err := getDriveTokenFromFile(); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("getDriveTokenFromFile() error = %v, want os.ErrNotExist", err)
+	}
+}
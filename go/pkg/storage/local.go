@@ -0,0 +1,288 @@
+package storage
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"slices"
+	"time"
+
+	"github.com/odedniv/osafe/go/pkg/encryption"
+)
+
+var localCopyPath = path.Join(".osafe", "local.json")     // Relative to os.UserHomeDir.
+var pendingSyncPath = path.Join(".osafe", "pending.json") // Relative to os.UserHomeDir.
+
+// offline is set when Read fell back to the local copy, so Write doesn't overwrite storages
+// without checking them for changes.
+var offline bool
+
+// PendingSync describes changes made offline that weren't written to the storages yet.
+type PendingSync struct {
+	// Base is the local copy the changes were made on, nil if there was none.
+	Base *encryption.Message
+	// Local is the changed message, pushed on the next Read that reaches the storages.
+	Local        encryption.Message
+	ModifiedTime time.Time
+}
+
+// PendingConflictError is returned by Read when storages changed since the pending sync's base.
+// Writing clears the pending sync, so the changes need to be merged first.
+type PendingConflictError struct {
+	Pending PendingSync
+	Latest  encryption.Message
+}
+
+func (e *PendingConflictError) Error() string {
+	return fmt.Sprintf("offline changes from %s conflict with changes in storage", e.Pending.ModifiedTime.Local().Format(time.DateTime))
+}
+
+func (e *PendingConflictError) Unwrap() error {
+	return ErrConflict
+}
+
+// pendingSyncFile is the marker saved while there's a pending sync, the changes themselves are
+// in the local copy.
+type pendingSyncFile struct {
+	Base json.RawMessage `json:"base,omitempty"`
+	// Local holds the changes once they conflict with storage, as the local copy is replaced with the
+	// latest version in storage for the merge to be written over.
+	Local        json.RawMessage `json:"local,omitempty"`
+	ModifiedTime time.Time       `json:"modifiedTime"`
+}
+
+// ReadPendingSync returns the changes made offline, or nil when there are none.
+func ReadPendingSync() (*PendingSync, error) {
+	p, err := readPendingSyncFile()
+	if err != nil || p == nil {
+		return nil, err
+	}
+	local, err := pendingLocal(p)
+	if err != nil {
+		return nil, err
+	}
+	ps := PendingSync{ModifiedTime: p.ModifiedTime}
+	if p.Base != nil {
+		if ps.Base, err = decode(p.Base); err != nil {
+			return nil, err
+		}
+	}
+	m, err := decode(local.bytes)
+	if err != nil {
+		return nil, err
+	}
+	ps.Local = *m
+	return &ps, nil
+}
+
+// DiscardPendingSync drops the changes made offline, e.g. after they were merged.
+func DiscardPendingSync() error {
+	name, err := userHomePath(pendingSyncPath)
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(name); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("failed deleting pending sync: %v", err)
+	}
+	return nil
+}
+
+// readOffline returns the local copy when no storage could be read, or the pending changes when
+// they conflict with storage.
+func readOffline(err error) (*encryption.Message, error) {
+	local, localErr := readLocalCopy()
+	if p, pErr := readPendingSyncFile(); pErr != nil {
+		return nil, pErr
+	} else if p != nil {
+		local, localErr = pendingLocal(p)
+	}
+	if localErr != nil {
+		return nil, localErr
+	} else if local.bytes == nil {
+		return nil, fmt.Errorf("failed reading from storages, and there's no local copy: %w", err)
+	}
+	warn("%v, using the local copy from %s", err, local.modifiedTime.Local().Format(time.DateTime))
+	offline = true
+	return decode(local.bytes)
+}
+
+// keepLocalCopy saves c as the local copy used when offline, only warning on failure.
+func keepLocalCopy(c content) {
+	if err := writeLocalCopy(c); err != nil {
+		warn("%v", err)
+	}
+}
+
+// writeOffline saves c as the local copy, marking it to be synced by the next Read.
+func writeOffline(c content) error {
+	p, err := readPendingSyncFile()
+	if err != nil {
+		return err
+	}
+	if p == nil {
+		// Keeping the base of the first change, to detect changes in storage since
+		base, err := readLocalCopy()
+		if err != nil {
+			return err
+		}
+		p = &pendingSyncFile{Base: base.bytes}
+	}
+	p.Local = nil // Replaced by c, which was edited over them.
+	p.ModifiedTime = c.modifiedTime
+	if err := writeLocalCopy(c); err != nil {
+		return err
+	}
+	if err := writePendingSyncFile(*p); err != nil {
+		return err
+	}
+	warn("saved changes locally, they will be synced on the next run with access to storages.")
+	return nil
+}
+
+// syncPending pushes changes made offline to the storages, if they didn't change since.
+// Returns the content to use as newest.
+func syncPending(newest contentStorage, cs []contentStorage) (contentStorage, error) {
+	p, err := readPendingSyncFile()
+	if err != nil || p == nil {
+		return newest, err
+	}
+	local, err := pendingLocal(p)
+	if err != nil {
+		return newest, err
+	}
+	switch {
+	case bytes.Equal(newest.content.bytes, local.bytes):
+		// Already synced, e.g. by another run that failed clearing the marker
+	case newest.content.bytes == nil || bytes.Equal(newest.content.bytes, p.Base):
+		// Pushing
+		newest = contentStorage{nil, local}
+		errs := writeOlder(newest, cs)
+		if err := failed(errs, slices.Contains(errs, nil)); err != nil {
+			return newest, fmt.Errorf("failed pushing offline changes to storages: %w", err)
+		}
+		fmt.Println("Synced offline changes to storages.")
+	default:
+		latest, err := decode(newest.content.bytes)
+		if err != nil {
+			return newest, err
+		}
+		// Basing the merge on the latest version, keeping the changes in the marker
+		if p.Local == nil {
+			p.Local = local.bytes
+			if err := writePendingSyncFile(*p); err != nil {
+				return newest, err
+			}
+		}
+		if err := writeLocalCopy(newest.content); err != nil {
+			return newest, err
+		}
+		ps, err := ReadPendingSync()
+		if err != nil {
+			return newest, err
+		}
+		return newest, &PendingConflictError{Pending: *ps, Latest: *latest}
+	}
+	return newest, DiscardPendingSync()
+}
+
+// pendingLocal returns the changes of the pending sync p.
+func pendingLocal(p *pendingSyncFile) (content, error) {
+	if p.Local != nil {
+		return content{p.Local, p.ModifiedTime}, nil
+	}
+	local, err := readLocalCopy()
+	if err != nil {
+		return content{}, err
+	} else if local.bytes == nil {
+		return content{}, errors.New("local copy with pending sync is missing")
+	}
+	return local, nil
+}
+
+func readPendingSyncFile() (*pendingSyncFile, error) {
+	name, err := userHomePath(pendingSyncPath)
+	if err != nil {
+		return nil, err
+	}
+	bytes, err := os.ReadFile(name)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("failed reading pending sync: %v", err)
+	}
+	var p pendingSyncFile
+	if err := json.Unmarshal(bytes, &p); err != nil {
+		return nil, fmt.Errorf("failed unmarshaling pending sync: %v", err)
+	}
+	return &p, nil
+}
+
+func writePendingSyncFile(p pendingSyncFile) error {
+	bytes, err := json.Marshal(p)
+	if err != nil {
+		return fmt.Errorf("failed marshaling pending sync: %v", err)
+	}
+	return writeHomeFile(pendingSyncPath, bytes)
+}
+
+func readLocalCopy() (content, error) {
+	name, err := userHomePath(localCopyPath)
+	if err != nil {
+		return content{}, err
+	}
+	stat, err := os.Stat(name)
+	if errors.Is(err, os.ErrNotExist) {
+		return content{}, nil
+	} else if err != nil {
+		return content{}, fmt.Errorf("failed getting local copy modified time: %v", err)
+	}
+	bytes, err := os.ReadFile(name)
+	if err != nil {
+		return content{}, fmt.Errorf("failed reading local copy: %v", err)
+	}
+	return content{bytes, stat.ModTime()}, nil
+}
+
+func writeLocalCopy(c content) error {
+	if err := writeHomeFile(localCopyPath, c.bytes); err != nil {
+		return err
+	}
+	name, err := userHomePath(localCopyPath)
+	if err != nil {
+		return err
+	}
+	if err := os.Chtimes(name, c.modifiedTime, c.modifiedTime); err != nil {
+		return fmt.Errorf("failed setting local copy modified time: %v", err)
+	}
+	return nil
+}
+
+// writeHomeFile atomically replaces a private file relative to os.UserHomeDir.
+func writeHomeFile(name string, bytes []byte) error {
+	name, err := userHomePath(name)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(path.Dir(name), 0700); err != nil {
+		return fmt.Errorf("failed creating dir for %s: %v", name, err)
+	}
+	f, err := os.CreateTemp(path.Dir(name), path.Base(name)+".*.tmp")
+	if err != nil {
+		return fmt.Errorf("failed creating temp file for %s: %v", name, err)
+	}
+	defer os.Remove(f.Name())
+	if _, err := f.Write(bytes); err != nil {
+		f.Close()
+		return fmt.Errorf("failed writing %s: %v", name, err)
+	}
+	if err := f.Close(); err != nil {
+		return fmt.Errorf("failed closing %s: %v", name, err)
+	}
+	if err := os.Rename(f.Name(), name); err != nil {
+		return fmt.Errorf("failed replacing %s: %v", name, err)
+	}
+	return nil
+}
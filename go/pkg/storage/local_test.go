@@ -0,0 +1,126 @@
+package storage
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/odedniv/osafe/go/pkg/config"
+	"github.com/odedniv/osafe/go/pkg/drivefake"
+	"github.com/odedniv/osafe/go/pkg/encryption"
+)
+
+func testMessage(text string) encryption.Message {
+	return encryption.Message{Content: encryption.Content{Content: []byte(text)}}
+}
+
+func TestOfflineWriteSynced(t *testing.T) {
+	srv := drivefake.NewServer()
+	defer srv.Close()
+	useStorages(t, newDriveStorage(srv, config.Drive{}))
+	if err := Write(testMessage("base")); err != nil {
+		t.Fatalf("Write() error = %v", err)
+	}
+
+	// Offline
+	srv.Fail(http.StatusServiceUnavailable)
+	if m, err := Read(); err != nil || string(m.Content.Content) != "base" {
+		t.Fatalf("Read() offline = %+v, %v, want the local copy", m, err)
+	}
+	if err := Write(testMessage("offline")); err != nil {
+		t.Fatalf("Write() offline error = %v", err)
+	}
+	if ps, err := ReadPendingSync(); err != nil || ps == nil || string(ps.Local.Content.Content) != "offline" {
+		t.Fatalf("ReadPendingSync() = %+v, %v, want the offline changes", ps, err)
+	}
+	// Back online
+	m, err := Read()
+	if err != nil || string(m.Content.Content) != "offline" || m.Revision != 2 {
+		t.Fatalf("Read() = %+v, %v, want the offline changes at revision 2", m, err)
+	}
+	if ps, err := ReadPendingSync(); err != nil || ps != nil {
+		t.Errorf("ReadPendingSync() = %+v, %v, want none", ps, err)
+	}
+}
+
+func TestOfflineWriteConflict(t *testing.T) {
+	srv := drivefake.NewServer()
+	defer srv.Close()
+	useStorages(t, newDriveStorage(srv, config.Drive{}))
+	if err := Write(testMessage("base")); err != nil {
+		t.Fatalf("Write() error = %v", err)
+	}
+	// Offline
+	srv.Fail(http.StatusServiceUnavailable)
+	if _, err := Read(); err != nil {
+		t.Fatalf("Read() offline error = %v", err)
+	}
+	if err := Write(testMessage("offline")); err != nil {
+		t.Fatalf("Write() offline error = %v", err)
+	}
+	// Meanwhile, several writes from another device
+	latest := testMessage("latest")
+	latest.Revision = 5
+	latestBytes, _ := json.Marshal(latest)
+	f := srv.Files()[0]
+	f.Content, f.Version, f.ModifiedTime = latestBytes, f.Version+1, time.Now()
+	srv.Put(f)
+
+	// Back online, conflicting until merged
+	storages[0] = newDriveStorage(srv, config.Drive{}) // A new run.
+	for range 2 {
+		_, err := Read()
+		var e *PendingConflictError
+		if !errors.As(err, &e) {
+			t.Fatalf("Read() error = %v, want PendingConflictError", err)
+		}
+		if string(e.Latest.Content.Content) != "latest" || string(e.Pending.Local.Content.Content) != "offline" ||
+			string(e.Pending.Base.Content.Content) != "base" {
+			t.Fatalf("Read() error = %+v, want latest, offline and base", e)
+		}
+	}
+	// Writing the merge over the latest version
+	if err := Write(testMessage("merged")); err != nil {
+		t.Fatalf("Write() error = %v", err)
+	}
+	var stored encryption.Message
+	if err := json.Unmarshal(srv.Files()[0].Content, &stored); err != nil {
+		t.Fatal(err)
+	}
+	if string(stored.Content.Content) != "merged" || stored.Revision != 6 || stored.Parent != contentHash(latestBytes) {
+		t.Errorf("stored = %+v, want merged at revision 6 with the latest as parent", stored)
+	}
+	if ps, err := ReadPendingSync(); err != nil || ps != nil {
+		t.Errorf("ReadPendingSync() = %+v, %v, want none", ps, err)
+	}
+}
+
+func TestOfflineReadPendingConflict(t *testing.T) {
+	srv := drivefake.NewServer()
+	defer srv.Close()
+	useStorages(t, newDriveStorage(srv, config.Drive{}))
+	if err := Write(testMessage("base")); err != nil {
+		t.Fatalf("Write() error = %v", err)
+	}
+	srv.Fail(http.StatusServiceUnavailable)
+	Read()
+	if err := Write(testMessage("offline")); err != nil {
+		t.Fatalf("Write() offline error = %v", err)
+	}
+	f := srv.Files()[0]
+	f.Content, f.Version = []byte(`{"revision":5}`), f.Version+1
+	srv.Put(f)
+	if _, err := Read(); !errors.Is(err, ErrConflict) {
+		t.Fatalf("Read() error = %v, want a conflict", err)
+	}
+
+	// Offline again, continuing with the changes rather than the latest version
+	srv.Fail(http.StatusServiceUnavailable)
+	m, err := Read()
+	if err != nil || !bytes.Equal(m.Content.Content, []byte("offline")) {
+		t.Errorf("Read() offline = %+v, %v, want the offline changes", m, err)
+	}
+}
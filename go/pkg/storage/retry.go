@@ -0,0 +1,69 @@
+package storage
+
+import (
+	"io"
+	"math/rand/v2"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+var retryAttempts = 5
+var retryBaseDelay = time.Millisecond * 500
+var retryMaxDelay = time.Second * 10
+
+// retryTransport retries requests that failed with 429 or 5xx, with exponential backoff and jitter.
+type retryTransport struct {
+	base http.RoundTripper
+}
+
+func newRetryClient() *http.Client {
+	return &http.Client{Transport: &retryTransport{base: http.DefaultTransport}}
+}
+
+func (t *retryTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	for attempt := 1; ; attempt++ {
+		r, err := t.base.RoundTrip(req)
+		if err != nil || !retryableStatus(r.StatusCode) || attempt == retryAttempts {
+			return r, err
+		}
+		// Body can't be sent again
+		if req.Body != nil && req.GetBody == nil {
+			return r, err
+		}
+		delay := retryDelay(attempt, r.Header.Get("Retry-After"))
+		io.Copy(io.Discard, r.Body)
+		r.Body.Close()
+		// Waiting
+		timer := time.NewTimer(delay)
+		select {
+		case <-req.Context().Done():
+			timer.Stop()
+			return nil, req.Context().Err()
+		case <-timer.C:
+		}
+		// Rewinding body
+		if req.GetBody != nil {
+			body, err := req.GetBody()
+			if err != nil {
+				return nil, err
+			}
+			req = req.Clone(req.Context())
+			req.Body = body
+		}
+	}
+}
+
+func retryableStatus(status int) bool {
+	return status == http.StatusTooManyRequests || status >= 500
+}
+
+// retryDelay returns a random delay of up to exponential backoff, or what the server asked for.
+func retryDelay(attempt int, retryAfter string) time.Duration {
+	backoff := min(retryBaseDelay<<(attempt-1), retryMaxDelay)
+	delay := rand.N(backoff) + 1
+	if seconds, err := strconv.Atoi(retryAfter); err == nil {
+		delay = max(delay, time.Duration(seconds)*time.Second)
+	}
+	return delay
+}
@@ -0,0 +1,210 @@
+package storage
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"slices"
+
+	"github.com/odedniv/osafe/go/pkg/encryption"
+)
+
+// DivergedError is returned when storages have versions that were changed independently of each
+// other, so none can replace the others without losing changes.
+type DivergedError struct {
+	// Versions that diverged, the one with the highest revision first.
+	Versions []encryption.Message
+	// Base is the common ancestor of the first two versions, nil when it's unknown.
+	Base *encryption.Message
+}
+
+func (e *DivergedError) Error() string {
+	return fmt.Sprintf("%d versions in storages were changed independently", len(e.Versions))
+}
+
+func (e *DivergedError) Unwrap() error {
+	return ErrConflict
+}
+
+// revision is the metadata kept in the message to tell ancestry apart from divergence.
+type revision struct {
+	Number int64  `json:"revision"`
+	Parent string `json:"parent"`
+	hash   string
+	bytes  []byte
+}
+
+func revisionOf(bytes []byte) (revision, error) {
+	var r revision
+	if err := json.Unmarshal(bytes, &r); err != nil {
+		return revision{}, fmt.Errorf("failed unmarshaling message revision: %v", err)
+	}
+	r.hash = contentHash(bytes)
+	r.bytes = bytes
+	return r, nil
+}
+
+func contentHash(bytes []byte) string {
+	sum := sha256.Sum256(bytes)
+	return hex.EncodeToString(sum[:])
+}
+
+// newestOf returns the newest content, and the contents that diverged from it. Contents are ordered
+// by revision when all have one, otherwise by modified time as written by older versions.
+func newestOf(cs []contentStorage) (contentStorage, []contentStorage, error) {
+	newest := slices.MaxFunc(cs, compareModifiedTime)
+	// Revisions
+	rs := map[string]revision{}
+	revisioned, unrevisioned := 0, 0 // Unrevisioned are written by a version that doesn't keep revisions.
+	for _, c := range cs {
+		if c.content.bytes == nil {
+			continue
+		}
+		r, err := revisionOf(c.content.bytes)
+		if err != nil {
+			return contentStorage{}, nil, err
+		} else if r.Number == 0 {
+			unrevisioned++
+			continue
+		}
+		revisioned++
+		rs[r.hash] = r
+	}
+	if unrevisioned > 0 && revisioned > 0 {
+		warn("%d of %d copies have no revision, as written by the Android app, using the last modified. "+
+			"Changes can be lost when device clocks differ.", unrevisioned, unrevisioned+revisioned)
+	}
+	if unrevisioned > 0 || len(rs) == 0 {
+		return newest, nil, nil // Or no storage has a file yet.
+	}
+	newest = slices.MaxFunc(cs, func(a, b contentStorage) int {
+		if c := compareRevision(rs, a, b); c != 0 {
+			return c
+		}
+		return compareModifiedTime(a, b)
+	})
+	// Diverged
+	h := history{known: rs}
+	var diverged []contentStorage
+	for _, c := range cs {
+		if c.content.bytes == nil || bytes.Equal(c.content.bytes, newest.content.bytes) {
+			continue
+		}
+		if slices.ContainsFunc(diverged, func(d contentStorage) bool { return bytes.Equal(d.content.bytes, c.content.bytes) }) {
+			continue
+		}
+		if !h.descends(rs[contentHash(newest.content.bytes)], rs[contentHash(c.content.bytes)]) {
+			diverged = append(diverged, c)
+		}
+	}
+	return newest, diverged, nil
+}
+
+func compareRevision(rs map[string]revision, a, b contentStorage) int {
+	var an, bn int64 // Missing files are the oldest.
+	if a.content.bytes != nil {
+		an = rs[contentHash(a.content.bytes)].Number
+	}
+	if b.content.bytes != nil {
+		bn = rs[contentHash(b.content.bytes)].Number
+	}
+	return int(min(max(an-bn, -1), 1))
+}
+
+// divergedError describes the diverged contents, finding their common ancestor in the history.
+func divergedError(newest contentStorage, diverged []contentStorage) (*DivergedError, error) {
+	e := &DivergedError{}
+	var rs []revision
+	for _, c := range append([]contentStorage{newest}, diverged...) {
+		m, err := decode(c.content.bytes)
+		if err != nil {
+			return nil, err
+		}
+		e.Versions = append(e.Versions, *m)
+		r, err := revisionOf(c.content.bytes)
+		if err != nil {
+			return nil, err
+		}
+		rs = append(rs, r)
+	}
+	h := history{known: map[string]revision{}}
+	for _, r := range rs {
+		h.known[r.hash] = r
+	}
+	if base, ok := h.commonAncestor(rs[0], rs[1]); ok {
+		m, err := decode(base.bytes)
+		if err != nil {
+			return nil, err
+		}
+		e.Base = m
+	}
+	return e, nil
+}
+
+// history finds earlier revisions by hash, in the storages, the local copy and the backups.
+type history struct {
+	known  map[string]revision
+	loaded bool
+}
+
+// descends returns whether a is b or was written after it, trusting the revision numbers when
+// the history in between is unknown.
+func (h *history) descends(a, b revision) bool {
+	for a.Number > b.Number {
+		parent, ok := h.get(a.Parent)
+		if !ok {
+			return true
+		}
+		a = parent
+	}
+	return a.hash == b.hash
+}
+
+func (h *history) commonAncestor(a, b revision) (revision, bool) {
+	ancestors := map[string]bool{}
+	for r, ok := a, true; ok; r, ok = h.get(r.Parent) {
+		ancestors[r.hash] = true
+	}
+	for r, ok := b, true; ok; r, ok = h.get(r.Parent) {
+		if ancestors[r.hash] {
+			return r, true
+		}
+	}
+	return revision{}, false
+}
+
+func (h *history) get(hash string) (revision, bool) {
+	if hash == "" {
+		return revision{}, false
+	}
+	if r, ok := h.known[hash]; ok {
+		return r, true
+	}
+	if !h.loaded {
+		h.load()
+		return h.get(hash)
+	}
+	return revision{}, false
+}
+
+// load adds the local copy and backups to the known revisions, ignoring unreadable ones.
+func (h *history) load() {
+	h.loaded = true
+	var versions [][]byte
+	if local, err := readLocalCopy(); err == nil && local.bytes != nil {
+		versions = append(versions, local.bytes)
+	}
+	backups, _ := Backups()
+	for _, b := range backups {
+		if bytes, err := readBackup(b.Id); err == nil {
+			versions = append(versions, bytes)
+		}
+	}
+	for _, bytes := range versions {
+		if r, err := revisionOf(bytes); err == nil && r.Number > 0 {
+			h.known[r.hash] = r
+		}
+	}
+}
@@ -0,0 +1,39 @@
+package storage
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestNewestOf(t *testing.T) {
+	now := time.Now()
+	base := []byte(`{"revision":1}`)
+	child := []byte(fmt.Sprintf(`{"revision":2,"parent":"%s"}`, contentHash(base)))
+	other := []byte(fmt.Sprintf(`{"revision":2,"parent":"%s","content":"other"}`, contentHash(base)))
+	android := []byte(`{"content":"android"}`)
+	tests := []struct {
+		name     string
+		cs       []content
+		want     []byte
+		diverged int
+	}{
+		{name: "by revision", cs: []content{{child, now.Add(-time.Hour)}, {base, now}}, want: child},
+		{name: "diverged", cs: []content{{child, now.Add(-time.Hour)}, {other, now}}, want: other, diverged: 1},
+		{name: "without revision", cs: []content{{child, now.Add(-time.Hour)}, {android, now}}, want: android},
+		{name: "missing", cs: []content{{nil, now}, {base, now.Add(-time.Hour)}}, want: base},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var cs []contentStorage
+			for _, c := range tt.cs {
+				cs = append(cs, contentStorage{content: c})
+			}
+			newest, diverged, err := newestOf(cs)
+			if err != nil || !bytes.Equal(newest.content.bytes, tt.want) || len(diverged) != tt.diverged {
+				t.Errorf("newestOf() = %s, %d diverged, %v, want %s, %d diverged", newest.content.bytes, len(diverged), err, tt.want, tt.diverged)
+			}
+		})
+	}
+}
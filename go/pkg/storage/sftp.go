@@ -0,0 +1,259 @@
+package storage
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path"
+
+	"github.com/odedniv/osafe/go/pkg/config"
+	"github.com/pkg/sftp"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+var sftpDefaultIdentityFiles = []string{ // Relative to os.UserHomeDir.
+	path.Join(".ssh", "id_ed25519"),
+	path.Join(".ssh", "id_ecdsa"),
+	path.Join(".ssh", "id_rsa"),
+}
+var sftpDefaultKnownHostsFile = path.Join(".ssh", "known_hosts") // Relative to os.UserHomeDir.
+
+type sftpStorage struct {
+	config config.SFTP
+	client *sftp.Client
+	hash   string // Of the remote file's content, empty when it didn't exist.
+	known  bool
+}
+
+func (s *sftpStorage) name() string {
+	return "sftp"
+}
+
+func (s *sftpStorage) read(ctx context.Context) (content, error) {
+	if err := s.prepare(ctx); err != nil {
+		return content{}, fmt.Errorf("failed preparing sftp storage for read: %v", err)
+	}
+	defer s.interruptible(ctx)()
+	// Opening
+	f, err := s.client.Open(s.config.Path)
+	if errors.Is(err, os.ErrNotExist) {
+		s.hash, s.known = "", true
+		return content{}, nil
+	} else if err != nil {
+		return content{}, fmt.Errorf("failed opening sftp storage for read: %v", err)
+	}
+	defer f.Close()
+	stat, err := f.Stat()
+	if err != nil {
+		return content{}, fmt.Errorf("failed getting sftp storage modified time: %v", err)
+	}
+	// Reading
+	bytes, err := io.ReadAll(f)
+	if err != nil {
+		return content{}, fmt.Errorf("failed reading from sftp storage: %v", err)
+	}
+	s.hash, s.known = contentHash(bytes), true
+	return content{bytes, stat.ModTime()}, nil
+}
+
+func (s *sftpStorage) write(ctx context.Context, c content) error {
+	if err := s.prepare(ctx); err != nil {
+		return fmt.Errorf("failed preparing sftp storage for write: %v", err)
+	}
+	defer s.interruptible(ctx)()
+	// Making sure we're not overwriting a change since read, by content as modified times only have a
+	// resolution of seconds
+	if s.known {
+		hash, err := s.remoteHash()
+		if err != nil {
+			return err
+		}
+		if hash != s.hash {
+			return fmt.Errorf("sftp storage was modified since it was read: %w", ErrConflict)
+		}
+	}
+	// Writing to a temp file, then atomically replacing
+	temp, err := sftpTempPath(s.config.Path)
+	if err != nil {
+		return err
+	}
+	if err := s.writeFile(temp, c); err != nil {
+		s.client.Remove(temp)
+		return err
+	}
+	if err := s.client.PosixRename(temp, s.config.Path); err != nil {
+		s.client.Remove(temp)
+		return fmt.Errorf("failed renaming sftp storage temp file: %v", err)
+	}
+	// Updating state for the next write
+	s.hash, s.known = contentHash(c.bytes), true
+	return nil
+}
+
+// remoteHash returns the hash of the remote file's content, or an empty string when it doesn't exist.
+func (s *sftpStorage) remoteHash() (string, error) {
+	f, err := s.client.Open(s.config.Path)
+	if errors.Is(err, os.ErrNotExist) {
+		return "", nil
+	} else if err != nil {
+		return "", fmt.Errorf("failed opening sftp storage for write: %v", err)
+	}
+	defer f.Close()
+	bytes, err := io.ReadAll(f)
+	if err != nil {
+		return "", fmt.Errorf("failed reading sftp storage for write: %v", err)
+	}
+	return contentHash(bytes), nil
+}
+
+func (s *sftpStorage) writeFile(name string, c content) error {
+	f, err := s.client.OpenFile(name, os.O_WRONLY|os.O_CREATE|os.O_EXCL)
+	if err != nil {
+		return fmt.Errorf("failed creating sftp storage temp file: %v", err)
+	}
+	defer f.Close()
+	if err := f.Chmod(0600); err != nil {
+		return fmt.Errorf("failed protecting sftp storage temp file: %v", err)
+	}
+	if _, err := f.Write(c.bytes); err != nil {
+		return fmt.Errorf("failed writing to sftp storage temp file: %v", err)
+	}
+	if err := f.Close(); err != nil {
+		return fmt.Errorf("failed closing sftp storage temp file: %v", err)
+	}
+	if err := s.client.Chtimes(name, c.modifiedTime, c.modifiedTime); err != nil {
+		return fmt.Errorf("failed setting sftp storage modified time: %v", err)
+	}
+	return nil
+}
+
+// interruptible closes the connection when ctx is done, as the SFTP client doesn't take a context.
+// The returned function must be called when the operation ends.
+func (s *sftpStorage) interruptible(ctx context.Context) func() {
+	client := s.client
+	stop := context.AfterFunc(ctx, func() { client.Close() })
+	return func() {
+		if !stop() {
+			s.client = nil // Closed, connect again on the next operation.
+		}
+	}
+}
+
+func (s *sftpStorage) prepare(ctx context.Context) error {
+	if s.client != nil {
+		return nil // Already prepared.
+	}
+	// Authenticating
+	var auths []ssh.AuthMethod
+	var agentErr error
+	if sock := os.Getenv("SSH_AUTH_SOCK"); sock != "" {
+		// A stale agent socket is common, e.g. in a re-attached terminal multiplexer, falling back to key files.
+		agentConn, err := net.Dial("unix", sock)
+		if err != nil {
+			agentErr = fmt.Errorf("failed connecting to SSH agent: %v", err)
+		} else {
+			defer agentConn.Close() // Only needed for the handshake.
+			auths = append(auths, ssh.PublicKeysCallback(agent.NewClient(agentConn).Signers))
+		}
+	}
+	signers, err := sftpIdentitySigners(s.config.IdentityFiles)
+	if err != nil {
+		return err
+	}
+	if len(signers) > 0 {
+		auths = append(auths, ssh.PublicKeys(signers...))
+	}
+	if len(auths) == 0 && agentErr != nil {
+		return fmt.Errorf("no SSH identity files found, and %v", agentErr)
+	}
+	// Verifying host
+	knownHostsFile := s.config.KnownHostsFile
+	if knownHostsFile == "" {
+		knownHostsFile = sftpDefaultKnownHostsFile
+	}
+	knownHostsFile, err = userHomePath(knownHostsFile)
+	if err != nil {
+		return err
+	}
+	hostKeyCallback, err := knownhosts.New(knownHostsFile)
+	if err != nil {
+		return fmt.Errorf("failed reading SSH known hosts: %v", err)
+	}
+	// Connecting
+	addr := s.config.Host
+	if _, _, err := net.SplitHostPort(addr); err != nil {
+		addr = net.JoinHostPort(addr, "22")
+	}
+	netConn, err := (&net.Dialer{}).DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return fmt.Errorf("failed connecting to SSH server: %v", err)
+	}
+	stop := context.AfterFunc(ctx, func() { netConn.Close() }) // Interrupting the handshake.
+	defer stop()
+	sshConn, chans, reqs, err := ssh.NewClientConn(netConn, addr, &ssh.ClientConfig{
+		User:            s.config.User,
+		Auth:            auths,
+		HostKeyCallback: hostKeyCallback,
+	})
+	if err != nil {
+		netConn.Close()
+		return fmt.Errorf("failed connecting to SSH server: %v", err)
+	}
+	conn := ssh.NewClient(sshConn, chans, reqs)
+	client, err := sftp.NewClient(conn)
+	if err != nil {
+		conn.Close()
+		return fmt.Errorf("failed starting SFTP session: %v", err)
+	}
+	if !stop() {
+		client.Close()
+		return fmt.Errorf("failed starting SFTP session: %v", ctx.Err())
+	}
+	s.client = client
+	return nil
+}
+
+func sftpIdentitySigners(identityFiles []string) ([]ssh.Signer, error) {
+	explicit := len(identityFiles) > 0
+	if !explicit {
+		identityFiles = sftpDefaultIdentityFiles
+	}
+
+	var signers []ssh.Signer
+	for _, identityFile := range identityFiles {
+		name, err := userHomePath(identityFile)
+		if err != nil {
+			return nil, err
+		}
+		key, err := os.ReadFile(name)
+		if os.IsNotExist(err) && !explicit {
+			continue // Default identity files are optional.
+		} else if err != nil {
+			return nil, fmt.Errorf("failed reading SSH identity file: %v", err)
+		}
+		signer, err := ssh.ParsePrivateKey(key)
+		var passphraseErr *ssh.PassphraseMissingError
+		if errors.As(err, &passphraseErr) && !explicit {
+			continue // Encrypted default keys are expected to be in the agent.
+		} else if err != nil {
+			return nil, fmt.Errorf("failed parsing SSH identity file %s: %v", name, err)
+		}
+		signers = append(signers, signer)
+	}
+	return signers, nil
+}
+
+func sftpTempPath(name string) (string, error) {
+	suffix := make([]byte, 8)
+	if _, err := rand.Read(suffix); err != nil {
+		return "", fmt.Errorf("failed generating sftp storage temp file name: %v", err)
+	}
+	return fmt.Sprintf("%s.%s.tmp", name, hex.EncodeToString(suffix)), nil
+}
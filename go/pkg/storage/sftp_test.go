@@ -0,0 +1,165 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/pem"
+	"errors"
+	"net"
+	"os"
+	"path"
+	"testing"
+	"time"
+
+	"github.com/odedniv/osafe/go/pkg/config"
+	"github.com/pkg/sftp"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+// newSFTPServer serves dir over SFTP, trusted by and trusting the keys it writes to a new HOME.
+func newSFTPServer(t *testing.T, dir string) config.SFTP {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	t.Setenv("SSH_AUTH_SOCK", path.Join(home, "missing-agent.sock")) // Falling back to key files.
+	if err := os.Mkdir(path.Join(home, ".ssh"), 0700); err != nil {
+		t.Fatal(err)
+	}
+	// Client key
+	clientPublic, clientPrivate, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	block, err := ssh.MarshalPrivateKey(clientPrivate, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path.Join(home, ".ssh", "id_ed25519"), pem.EncodeToMemory(block), 0600); err != nil {
+		t.Fatal(err)
+	}
+	clientKey, err := ssh.NewPublicKey(clientPublic)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Host key
+	_, hostPrivate, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hostKey, err := ssh.NewSignerFromKey(hostPrivate)
+	if err != nil {
+		t.Fatal(err)
+	}
+	serverConfig := &ssh.ServerConfig{
+		PublicKeyCallback: func(_ ssh.ConnMetadata, key ssh.PublicKey) (*ssh.Permissions, error) {
+			if !bytes.Equal(key.Marshal(), clientKey.Marshal()) {
+				return nil, errors.New("unknown key")
+			}
+			return nil, nil
+		},
+	}
+	serverConfig.AddHostKey(hostKey)
+	// Serving
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go serveSFTP(conn, serverConfig, dir)
+		}
+	}()
+	knownHosts := knownhosts.Line([]string{knownhosts.Normalize(l.Addr().String())}, hostKey.PublicKey())
+	if err := os.WriteFile(path.Join(home, ".ssh", "known_hosts"), []byte(knownHosts+"\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return config.SFTP{Host: l.Addr().String(), User: "test", Path: path.Join(dir, "osafe.json")}
+}
+
+func serveSFTP(conn net.Conn, serverConfig *ssh.ServerConfig, dir string) {
+	defer conn.Close()
+	_, chans, reqs, err := ssh.NewServerConn(conn, serverConfig)
+	if err != nil {
+		return
+	}
+	go ssh.DiscardRequests(reqs)
+	for newChannel := range chans {
+		if newChannel.ChannelType() != "session" {
+			newChannel.Reject(ssh.UnknownChannelType, "unknown channel type")
+			continue
+		}
+		channel, requests, err := newChannel.Accept()
+		if err != nil {
+			return
+		}
+		go func() {
+			for req := range requests {
+				ok := req.Type == "subsystem" && string(req.Payload[4:]) == "sftp"
+				req.Reply(ok, nil)
+				if !ok {
+					continue
+				}
+				server, err := sftp.NewServer(channel, sftp.WithServerWorkingDirectory(dir))
+				if err != nil {
+					channel.Close()
+					return
+				}
+				server.Serve()
+				server.Close()
+			}
+		}()
+	}
+}
+
+func TestSFTPRoundTrip(t *testing.T) {
+	c := newSFTPServer(t, t.TempDir())
+	ctx := context.Background()
+
+	s := &sftpStorage{config: c}
+	if got, err := s.read(ctx); err != nil || got.bytes != nil {
+		t.Fatalf("read() = %q, %v, want empty", got.bytes, err)
+	}
+	modifiedTime := time.Now().Truncate(time.Second)
+	if err := s.write(ctx, content{[]byte("first"), modifiedTime}); err != nil {
+		t.Fatalf("write() error = %v", err)
+	}
+	if err := s.write(ctx, content{[]byte("second"), modifiedTime}); err != nil {
+		t.Fatalf("second write() error = %v", err)
+	}
+
+	got, err := (&sftpStorage{config: c}).read(ctx)
+	if err != nil || !bytes.Equal(got.bytes, []byte("second")) || !got.modifiedTime.Equal(modifiedTime) {
+		t.Errorf("read() = %q at %v, %v, want %q at %v", got.bytes, got.modifiedTime, err, "second", modifiedTime)
+	}
+}
+
+func TestSFTPConflict(t *testing.T) {
+	c := newSFTPServer(t, t.TempDir())
+	ctx := context.Background()
+	modifiedTime := time.Now().Truncate(time.Second)
+	if err := (&sftpStorage{config: c}).write(ctx, content{[]byte("base"), modifiedTime}); err != nil {
+		t.Fatalf("write() error = %v", err)
+	}
+
+	s1 := &sftpStorage{config: c}
+	s2 := &sftpStorage{config: c}
+	for _, s := range []*sftpStorage{s1, s2} {
+		if _, err := s.read(ctx); err != nil {
+			t.Fatalf("read() error = %v", err)
+		}
+	}
+	// Same size and modified time, only the content differs
+	if err := s1.write(ctx, content{[]byte("BASE"), modifiedTime}); err != nil {
+		t.Fatalf("write() error = %v", err)
+	}
+	if err := s2.write(ctx, content{[]byte("mine"), modifiedTime}); !errors.Is(err, ErrConflict) {
+		t.Errorf("write() error = %v, want ErrConflict", err)
+	}
+}
@@ -2,60 +2,276 @@ package storage
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"os"
+	"path"
 	"slices"
 	"time"
 
+	"github.com/odedniv/osafe/go/pkg/config"
 	"github.com/odedniv/osafe/go/pkg/encryption"
 )
 
 var storageFilename = "osafe.json"
-var storages = []storage{&driveStorage{}}
+var storages []storage
+
+// readStorages were read successfully in this run, others are checked before writing to them.
+var readStorages = map[storage]bool{}
+var operationTimeout = time.Minute // Per storage read or write, including retries.
+
+// ErrConflict is returned when a storage changed since it was read, or in a way that can't be synced
+// without losing data. Reading again gets the latest version and allows writing over it.
+var ErrConflict = errors.New("sync conflict")
+
+// ErrOffline is returned when none of the storages could be read.
+var ErrOffline = errors.New("no storage could be read")
 
 func Read() (*encryption.Message, error) {
-	// Reading
-	cs, errs := readAll()
-	err := errors.Join(errs...)
-	if err != nil {
-		return nil, fmt.Errorf("failed reading from storages: %v", err)
-	} else if len(cs) == 0 {
-		return nil, nil
+	newest, err := syncAll()
+	if errors.Is(err, ErrOffline) {
+		return readOffline(err)
+	} else if err != nil {
+		return nil, err
 	}
-	// Finding newest
-	newest := slices.MaxFunc(cs, func(a, b contentStorage) int {
-		return time.Time.Compare(a.content.modifiedTime, b.content.modifiedTime)
-	})
-	// Writing to storages older than newest
-	err = errors.Join(writeOlder(newest, cs)...)
-	if err != nil {
-		return nil, fmt.Errorf("failed writing to old storages: %v", err)
+	if newest.bytes == nil {
+		return nil, nil // No storage has a file yet.
 	}
+	keepLocalCopy(newest)
 	// Decoding
-	var m encryption.Message
-	err = json.Unmarshal(newest.content.bytes, &m)
+	return decode(newest.bytes)
+}
+
+// Sync writes the newest version to the storages that are older, including changes made offline.
+func Sync() error {
+	newest, err := syncAll()
 	if err != nil {
-		return nil, fmt.Errorf("failed unmarshaling message from storage: %v", err)
+		return err
 	}
-	return &m, nil
+	if newest.bytes != nil {
+		keepLocalCopy(newest)
+	}
+	return nil
+}
+
+// StorageStatus describes the vault file in a storage.
+type StorageStatus struct {
+	Name string
+	// Exists is false when the storage has no vault file yet.
+	Exists       bool
+	ModifiedTime time.Time
+	Size         int
+	// Hash is the hex SHA-256 of the encrypted vault file.
+	Hash string
+	// Revision of the vault file, zero when written by a version that doesn't keep revisions.
+	Revision int64
+	// UpToDate is whether the storage has the newest version of all storages.
+	UpToDate bool
+	// Err is why the storage couldn't be read, the other fields are empty.
+	Err error
+}
+
+// Status reads all storages without syncing them.
+func Status() ([]StorageStatus, error) {
+	if err := prepareStorages(); err != nil {
+		return nil, err
+	}
+	cs, errs := readAll()
+	var newest content
+	if len(cs) > 0 {
+		n, _, err := newestOf(cs)
+		if err != nil {
+			return nil, err
+		}
+		newest = n.content
+	}
+
+	var statuses []StorageStatus
+	for i, s := range storages {
+		status := StorageStatus{Name: s.name(), Err: errs[i]}
+		if i := slices.IndexFunc(cs, func(c contentStorage) bool { return c.storage == s }); i != -1 {
+			c := cs[i].content
+			status.Exists = c.bytes != nil
+			status.ModifiedTime = c.modifiedTime
+			status.Size = len(c.bytes)
+			if c.bytes != nil {
+				status.Hash = contentHash(c.bytes)
+				r, err := revisionOf(c.bytes)
+				if err != nil {
+					return nil, err
+				}
+				status.Revision = r.Number
+			}
+			status.UpToDate = bytes.Equal(c.bytes, newest.bytes)
+		}
+		statuses = append(statuses, status)
+	}
+	return statuses, nil
 }
 
 func Write(m encryption.Message) error {
+	if err := prepareStorages(); err != nil {
+		return err
+	}
+	// Basing on the version being overwritten, which was last read or written
+	local, err := readLocalCopy()
+	if err != nil {
+		return err
+	}
+	if local.bytes != nil {
+		r, err := revisionOf(local.bytes)
+		if err != nil {
+			return err
+		}
+		m.Revision, m.Parent = r.Number+1, r.hash
+	} else {
+		m.Revision, m.Parent = 1, ""
+	}
+	tryBackup(local)
 	// Encoding
 	bytes, err := json.Marshal(m)
 	if err != nil {
 		return fmt.Errorf("failed marshaling message to storage: %v", err)
 	}
 	c := content{bytes, time.Now()}
+	if offline {
+		return writeOffline(c)
+	}
 	// Writing
-	err = errors.Join(writeAll(c)...)
+	errs := writeAll(c, local.bytes)
+	if !slices.Contains(errs, nil) && !errors.Is(errors.Join(errs...), ErrConflict) {
+		warn("failed writing to storages: %v", errors.Join(errs...))
+		return writeOffline(c)
+	}
+	if err := failed(errs, true); err != nil {
+		return fmt.Errorf("failed writing to storages: %w", err)
+	}
+	keepLocalCopy(c)
+	return DiscardPendingSync()
+}
+
+// syncAll reads all storages and writes the newest version to older ones, returning it.
+// Returns ErrOffline when no storage could be read.
+func syncAll() (content, error) {
+	if err := prepareStorages(); err != nil {
+		return content{}, err
+	}
+	// Reading
+	cs, errs := readAll()
+	if len(cs) == 0 {
+		return content{}, fmt.Errorf("%w: %w", ErrOffline, errors.Join(errs...))
+	}
+	if err := failed(errs, true); err != nil {
+		return content{}, fmt.Errorf("failed reading from storages: %w", err)
+	}
+	offline = false
+	// Finding newest
+	newest, diverged, err := newestOf(cs)
+	if err != nil {
+		return content{}, err
+	}
+	if len(diverged) > 0 {
+		// Backing up the diverged versions, and basing the next write on the newest
+		for _, c := range diverged {
+			tryBackup(c.content)
+		}
+		keepLocalCopy(newest.content)
+		e, err := divergedError(newest, diverged)
+		if err != nil {
+			return content{}, err
+		}
+		return content{}, e
+	}
+	// Syncing changes made offline
+	newest, err = syncPending(newest, cs)
+	if err != nil {
+		return content{}, err
+	}
+	if newest.content.bytes == nil {
+		return content{}, nil // No storage has a file yet.
+	}
+	// Writing to storages older than newest, which is already stored in at least one
+	if err := failed(writeOlder(newest, cs), true); err != nil {
+		return content{}, fmt.Errorf("failed writing to old storages: %w", err)
+	}
+	return newest.content, nil
+}
+
+func compareModifiedTime(a, b contentStorage) int {
+	return time.Time.Compare(a.content.modifiedTime, b.content.modifiedTime)
+}
+
+// failed returns an error when there was a conflict or when no storage succeeded, otherwise only
+// warns about the failed storages.
+func failed(errs []error, succeeded bool) error {
+	err := errors.Join(errs...)
+	if err == nil {
+		return nil
+	} else if !succeeded || errors.Is(err, ErrConflict) {
+		return err
+	}
+	for _, err := range errs {
+		if err != nil {
+			warn("%v", err)
+		}
+	}
+	return nil
+}
+
+func warn(format string, a ...any) {
+	fmt.Fprintf(os.Stderr, "Warning: "+format+"\n", a...)
+}
+
+func prepareStorages() error {
+	if storages != nil {
+		return nil // Already prepared.
+	}
+	c, err := config.Load()
 	if err != nil {
-		return fmt.Errorf("failed writing to storages: %v", err)
+		return fmt.Errorf("failed loading config for storages: %v", err)
+	}
+	if c.Drive != nil {
+		storages = append(storages, &driveStorage{config: *c.Drive})
+	}
+	if c.WebDAV != nil {
+		storages = append(storages, &webdavStorage{config: *c.WebDAV})
+	}
+	if c.Git != nil {
+		storages = append(storages, &gitStorage{config: *c.Git})
+	}
+	if c.SFTP != nil {
+		storages = append(storages, &sftpStorage{config: *c.SFTP})
+	}
+	backupsConfig = c.Backups
+	if len(storages) == 0 {
+		return fmt.Errorf("no storages configured")
 	}
 	return nil
 }
 
+func decode(bytes []byte) (*encryption.Message, error) {
+	var m encryption.Message
+	err := json.Unmarshal(bytes, &m)
+	if err != nil {
+		return nil, fmt.Errorf("failed unmarshaling message from storage: %v", err)
+	}
+	return &m, nil
+}
+
+// userHomePath resolves name relative to os.UserHomeDir, unless it's absolute.
+func userHomePath(name string) (string, error) {
+	if path.IsAbs(name) {
+		return name, nil
+	}
+	homeDir, err := os.UserHomeDir()
+	if err != nil {
+		return "", fmt.Errorf("failed getting user home dir: %v", err)
+	}
+	return path.Join(homeDir, name), nil
+}
+
 func readAll() ([]contentStorage, []error) {
 	type info struct {
 		s   storage
@@ -65,10 +281,16 @@ func readAll() ([]contentStorage, []error) {
 
 	var chs [](chan info)
 	for _, s := range storages {
-		ch := make(chan info)
+		ch := make(chan info, 1)
 		chs = append(chs, ch)
+		if err := prepare(s); err != nil {
+			ch <- info{s, content{}, err}
+			continue
+		}
 		go func(s storage) {
-			c, err := s.read()
+			ctx, cancel := context.WithTimeout(context.Background(), operationTimeout)
+			defer cancel()
+			c, err := s.read(ctx)
 			ch <- info{s, c, err}
 		}(s)
 	}
@@ -78,7 +300,10 @@ func readAll() ([]contentStorage, []error) {
 	for _, ch := range chs {
 		info := <-ch
 		errs = append(errs, info.err)
-		contents = append(contents, contentStorage{info.s, info.c})
+		if info.err == nil {
+			contents = append(contents, contentStorage{info.s, info.c})
+			readStorages[info.s] = true
+		}
 	}
 
 	return contents, errs
@@ -90,9 +315,10 @@ func writeOlder(newest contentStorage, cs []contentStorage) []error {
 		if c.storage == newest.storage || bytes.Equal(c.content.bytes, newest.content.bytes) {
 			continue
 		}
+		tryBackup(c.content)
 		ch := make(chan error)
 		chs = append(chs, ch)
-		go func(s storage) { ch <- s.write(newest.content) }(c.storage)
+		go func(s storage) { ch <- write(s, newest.content) }(c.storage)
 	}
 
 	var errs []error
@@ -102,24 +328,92 @@ func writeOlder(newest contentStorage, cs []contentStorage) []error {
 	return errs
 }
 
-func writeAll(c content) []error {
-	var chs [](chan error)
-	for _, s := range storages {
-		ch := make(chan error)
-		chs = append(chs, ch)
-		go func(s storage) { ch <- s.write(c) }(s)
+// writeAll writes c to all storages, base is the version c replaces.
+func writeAll(c content, base []byte) []error {
+	errs := checkUnread(base)
+	if errors.Is(errors.Join(errs...), ErrConflict) {
+		return errs // Not writing anywhere, reading again gets the version to merge with.
+	}
+	chs := make([](chan error), len(storages))
+	for i, s := range storages {
+		if errs[i] != nil {
+			continue
+		}
+		chs[i] = make(chan error)
+		go func(s storage) { chs[i] <- write(s, c) }(s)
 	}
 
-	var errs []error
-	for _, ch := range chs {
-		errs = append(errs, <-ch)
+	for i, ch := range chs {
+		if ch != nil {
+			errs[i] = <-ch
+		}
 	}
 	return errs
 }
 
+// checkUnread reads the storages that failed to read in this run, as they have no version to compare
+// with and would be overwritten regardless of their content. Storages without a vault file or with
+// base can be written to, others conflict.
+func checkUnread(base []byte) []error {
+	chs := make([](chan error), len(storages))
+	for i, s := range storages {
+		if readStorages[s] {
+			continue
+		}
+		chs[i] = make(chan error, 1)
+		if err := prepare(s); err != nil {
+			chs[i] <- fmt.Errorf("failed reading %s storage before write: %v", s.name(), err)
+			continue
+		}
+		go func(s storage) {
+			ctx, cancel := context.WithTimeout(context.Background(), operationTimeout)
+			defer cancel()
+			c, err := s.read(ctx)
+			if err != nil {
+				chs[i] <- fmt.Errorf("failed reading %s storage before write: %v", s.name(), err)
+			} else if c.bytes != nil && !bytes.Equal(c.bytes, base) {
+				chs[i] <- fmt.Errorf("%s storage has a version that wasn't read: %w", s.name(), ErrConflict)
+			} else {
+				chs[i] <- nil
+			}
+		}(s)
+	}
+
+	errs := make([]error, len(storages))
+	for i, ch := range chs {
+		if ch != nil {
+			errs[i] = <-ch
+		}
+	}
+	return errs
+}
+
+func write(s storage, c content) error {
+	ctx, cancel := context.WithTimeout(context.Background(), operationTimeout)
+	defer cancel()
+	return s.write(ctx, c)
+}
+
 type storage interface {
-	read() (content, error)
-	write(content content) error
+	// name is the storage's key in the config.
+	name() string
+	read(ctx context.Context) (content, error)
+	// write returns an error wrapping ErrConflict when the file changed since this storage last read
+	// or wrote it, so storages keep the version they last saw to compare with before overwriting.
+	write(ctx context.Context, content content) error
+}
+
+// preparer is implemented by storages that may interact with the user when preparing, e.g. to
+// authorize. Preparing is done one storage at a time before the operation's timeout starts.
+type preparer interface {
+	prepare() error
+}
+
+func prepare(s storage) error {
+	if p, ok := s.(preparer); ok {
+		return p.prepare()
+	}
+	return nil
 }
 
 type content struct {
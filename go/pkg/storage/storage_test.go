@@ -0,0 +1,59 @@
+package storage
+
+import (
+	"bytes"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/odedniv/osafe/go/pkg/config"
+	"github.com/odedniv/osafe/go/pkg/drivefake"
+)
+
+func TestWriteUnreadStorage(t *testing.T) {
+	tests := []struct {
+		name     string
+		failures int // Of the second storage's reads.
+		conflict bool
+	}{
+		{name: "still unreadable", failures: 2},
+		{name: "readable on write", failures: 1, conflict: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			now := time.Now().Truncate(time.Second)
+			first, second := drivefake.NewServer(), drivefake.NewServer()
+			defer first.Close()
+			defer second.Close()
+			first.Put(drivefake.File{Name: "osafe.json", Parents: []string{"root"}, ModifiedTime: now, Content: []byte(`{"revision":1}`)})
+			second.Put(drivefake.File{Name: "osafe.json", Parents: []string{"root"}, ModifiedTime: now, Content: []byte(`{"revision":9}`)})
+			useStorages(t, newDriveStorage(first, config.Drive{}), newDriveStorage(second, config.Drive{}))
+
+			for range tt.failures {
+				second.Fail(http.StatusForbidden)
+			}
+			m, err := Read()
+			if err != nil || m.Revision != 1 {
+				t.Fatalf("Read() = %+v, %v, want revision 1", m, err)
+			}
+			err = Write(*m)
+			if errors.Is(err, ErrConflict) != tt.conflict {
+				t.Fatalf("Write() error = %v, want conflict: %v", err, tt.conflict)
+			} else if !tt.conflict && err != nil {
+				t.Fatalf("Write() error = %v", err)
+			}
+
+			if got := second.Files()[0].Content; !bytes.Equal(got, []byte(`{"revision":9}`)) {
+				t.Errorf("unread storage = %s, want it kept", got)
+			}
+			wantFirst := 2
+			if tt.conflict {
+				wantFirst = 1 // Nothing written.
+			}
+			if m, err := decode(first.Files()[0].Content); err != nil || m.Revision != int64(wantFirst) {
+				t.Errorf("read storage = %+v, %v, want revision %d", m, err, wantFirst)
+			}
+		})
+	}
+}
@@ -0,0 +1,204 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/odedniv/osafe/go/pkg/config"
+)
+
+var webdavPropfindBody = []byte(`<?xml version="1.0" encoding="utf-8"?>` +
+	`<d:propfind xmlns:d="DAV:"><d:prop><d:getlastmodified/><d:getetag/></d:prop></d:propfind>`)
+
+type webdavStorage struct {
+	config config.WebDAV
+	client *http.Client
+	last   *webdavFileMetadata // Nil before the first read, or when the file didn't exist.
+	known  bool
+}
+
+type webdavFileMetadata struct {
+	etag         string // Empty when the server doesn't support ETags.
+	modifiedTime time.Time
+}
+
+type webdavMultistatus struct {
+	Responses []struct {
+		Propstats []struct {
+			Prop struct {
+				LastModified string `xml:"getlastmodified"`
+				ETag         string `xml:"getetag"`
+			} `xml:"prop"`
+			Status string `xml:"status"`
+		} `xml:"propstat"`
+	} `xml:"response"`
+}
+
+func (s *webdavStorage) name() string {
+	return "webdav"
+}
+
+func (s *webdavStorage) read(ctx context.Context) (content, error) {
+	s.prepare()
+	// Query
+	m, err := s.query(ctx)
+	if err != nil {
+		return content{}, fmt.Errorf("failed querying webdav storage for read: %v", err)
+	} else if m == nil {
+		s.last, s.known = nil, true
+		return content{}, nil
+	}
+	// Downloading
+	req, err := s.newRequest(ctx, http.MethodGet, nil)
+	if err != nil {
+		return content{}, err
+	}
+	if m.etag != "" {
+		req.Header.Set("If-Match", m.etag) // Don't mix metadata and content of different versions.
+	}
+	r, err := s.client.Do(req)
+	if err != nil {
+		return content{}, fmt.Errorf("failed downloading from webdav storage: %v", err)
+	}
+	defer r.Body.Close()
+	if r.StatusCode != http.StatusOK {
+		return content{}, fmt.Errorf("failed downloading from webdav storage: %s", r.Status)
+	}
+
+	bytes, err := io.ReadAll(r.Body)
+	if err != nil {
+		return content{}, fmt.Errorf("failed reading from webdav storage: %v", err)
+	}
+	s.last, s.known = m, true
+	return content{bytes, m.modifiedTime}, nil
+}
+
+func (s *webdavStorage) write(ctx context.Context, c content) error {
+	s.prepare()
+	// Making sure we're not overwriting a change since read, as not all servers honor If-Match
+	if s.known {
+		m, err := s.query(ctx)
+		if err != nil {
+			return fmt.Errorf("failed querying webdav storage for write: %v", err)
+		}
+		if !webdavSameFile(s.last, m) {
+			return fmt.Errorf("webdav storage was modified since it was read: %w", ErrConflict)
+		}
+	}
+	// Uploading
+	req, err := s.newRequest(ctx, http.MethodPut, bytes.NewReader(c.bytes))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	// Nextcloud and ownCloud keep this as the file's modified time.
+	req.Header.Set("X-OC-MTime", strconv.FormatInt(c.modifiedTime.Unix(), 10))
+	if s.known && s.last == nil {
+		req.Header.Set("If-None-Match", "*")
+	} else if s.known && s.last.etag != "" {
+		req.Header.Set("If-Match", s.last.etag)
+	}
+
+	r, err := s.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed uploading to webdav storage: %v", err)
+	}
+	defer r.Body.Close()
+	switch r.StatusCode {
+	case http.StatusOK, http.StatusCreated, http.StatusNoContent:
+	case http.StatusPreconditionFailed:
+		return fmt.Errorf("webdav storage was modified since it was read: %w", ErrConflict)
+	default:
+		return fmt.Errorf("failed uploading to webdav storage: %s", r.Status)
+	}
+	// Updating state for the next write
+	if etag := r.Header.Get("ETag"); etag != "" {
+		s.last, s.known = &webdavFileMetadata{etag: etag}, true
+		return nil
+	}
+	m, err := s.query(ctx)
+	if err != nil || m == nil {
+		s.last, s.known = nil, false
+		return fmt.Errorf("failed querying webdav storage after write: %v", err)
+	}
+	s.last, s.known = m, true
+	return nil
+}
+
+// query returns nil when the file doesn't exist.
+func (s *webdavStorage) query(ctx context.Context) (*webdavFileMetadata, error) {
+	req, err := s.newRequest(ctx, "PROPFIND", bytes.NewReader(webdavPropfindBody))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/xml; charset=utf-8")
+	req.Header.Set("Depth", "0")
+
+	r, err := s.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed requesting webdav properties: %v", err)
+	}
+	defer r.Body.Close()
+	switch r.StatusCode {
+	case http.StatusMultiStatus:
+	case http.StatusNotFound:
+		return nil, nil
+	default:
+		return nil, fmt.Errorf("failed requesting webdav properties: %s", r.Status)
+	}
+
+	var ms webdavMultistatus
+	if err := xml.NewDecoder(r.Body).Decode(&ms); err != nil {
+		return nil, fmt.Errorf("failed parsing webdav properties: %v", err)
+	}
+	for _, response := range ms.Responses {
+		for _, propstat := range response.Propstats {
+			if !strings.Contains(propstat.Status, " 200 ") || propstat.Prop.LastModified == "" {
+				continue
+			}
+			modifiedTime, err := http.ParseTime(propstat.Prop.LastModified)
+			if err != nil {
+				return nil, fmt.Errorf("failed parsing webdav modified time: %v", err)
+			}
+			return &webdavFileMetadata{propstat.Prop.ETag, modifiedTime}, nil
+		}
+	}
+	return nil, errors.New("webdav properties missing getlastmodified")
+}
+
+// webdavSameFile compares by ETag, or by modified time when the server doesn't support ETags. Modified
+// times only have a resolution of seconds, so changes within the same second aren't detected then.
+func webdavSameFile(a, b *webdavFileMetadata) bool {
+	if a == nil || b == nil {
+		return a == nil && b == nil
+	}
+	if a.etag != "" || b.etag != "" {
+		return a.etag == b.etag
+	}
+	return a.modifiedTime.Equal(b.modifiedTime)
+}
+
+func (s *webdavStorage) newRequest(ctx context.Context, method string, body io.Reader) (*http.Request, error) {
+	req, err := http.NewRequestWithContext(ctx, method, s.config.URL, body)
+	if err != nil {
+		return nil, fmt.Errorf("failed creating webdav %s request: %v", method, err)
+	}
+	if s.config.Username != "" || s.config.Password != "" {
+		req.SetBasicAuth(s.config.Username, s.config.Password)
+	}
+	return req, nil
+}
+
+func (s *webdavStorage) prepare() {
+	if s.client == nil {
+		s.client = newRetryClient()
+	}
+}
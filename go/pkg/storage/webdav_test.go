@@ -0,0 +1,123 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"testing"
+	"time"
+
+	"golang.org/x/net/webdav"
+
+	"github.com/odedniv/osafe/go/pkg/config"
+)
+
+var webdavETagRegexp = regexp.MustCompile(`<D:getetag>[^<]*</D:getetag>`)
+
+func newWebDAVServer(t *testing.T, etags bool) string {
+	var h http.Handler = &webdav.Handler{FileSystem: webdav.NewMemFS(), LockSystem: webdav.NewMemLS()}
+	if !etags {
+		h = withoutETags(h)
+	}
+	srv := httptest.NewServer(h)
+	t.Cleanup(srv.Close)
+	return srv.URL + "/osafe.json"
+}
+
+// withoutETags serves like a server that doesn't support ETags.
+func withoutETags(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, r)
+		for k, v := range rec.Header() {
+			if k != "Etag" && k != "Content-Length" {
+				w.Header()[k] = v
+			}
+		}
+		w.WriteHeader(rec.Code)
+		w.Write(webdavETagRegexp.ReplaceAll(rec.Body.Bytes(), nil))
+	})
+}
+
+func TestWebDAVRoundTrip(t *testing.T) {
+	for _, etags := range []bool{true, false} {
+		s := &webdavStorage{config: config.WebDAV{URL: newWebDAVServer(t, etags)}}
+		ctx := context.Background()
+
+		if c, err := s.read(ctx); err != nil || c.bytes != nil {
+			t.Fatalf("etags=%v: read() = %q, %v, want empty", etags, c.bytes, err)
+		}
+		modifiedTime := time.Now().Truncate(time.Second)
+		if err := s.write(ctx, content{[]byte("first"), modifiedTime}); err != nil {
+			t.Fatalf("etags=%v: write() error = %v", etags, err)
+		}
+		if err := s.write(ctx, content{[]byte("second"), modifiedTime}); err != nil {
+			t.Fatalf("etags=%v: second write() error = %v", etags, err)
+		}
+		c, err := (&webdavStorage{config: s.config}).read(ctx)
+		if err != nil || !bytes.Equal(c.bytes, []byte("second")) {
+			t.Errorf("etags=%v: read() = %q, %v, want %q", etags, c.bytes, err, "second")
+		}
+	}
+}
+
+func TestWebDAVExistingWithoutETag(t *testing.T) {
+	url := newWebDAVServer(t, false)
+	ctx := context.Background()
+	if err := (&webdavStorage{config: config.WebDAV{URL: url}}).write(ctx, content{[]byte("existing"), time.Now()}); err != nil {
+		t.Fatalf("write() error = %v", err)
+	}
+
+	s := &webdavStorage{config: config.WebDAV{URL: url}}
+	if _, err := s.read(ctx); err != nil {
+		t.Fatalf("read() error = %v", err)
+	}
+	if err := s.write(ctx, content{[]byte("updated"), time.Now()}); err != nil {
+		t.Errorf("write() error = %v", err)
+	}
+}
+
+func TestWebDAVConflict(t *testing.T) {
+	for _, etags := range []bool{true, false} {
+		url := newWebDAVServer(t, etags)
+		ctx := context.Background()
+		if err := (&webdavStorage{config: config.WebDAV{URL: url}}).write(ctx, content{[]byte("base"), time.Now()}); err != nil {
+			t.Fatalf("etags=%v: write() error = %v", etags, err)
+		}
+
+		s1 := &webdavStorage{config: config.WebDAV{URL: url}}
+		s2 := &webdavStorage{config: config.WebDAV{URL: url}}
+		for _, s := range []*webdavStorage{s1, s2} {
+			if _, err := s.read(ctx); err != nil {
+				t.Fatalf("etags=%v: read() error = %v", etags, err)
+			}
+		}
+		if !etags {
+			time.Sleep(time.Second + time.Millisecond*100) // Modified times only have a resolution of seconds.
+		}
+		if err := s1.write(ctx, content{[]byte("first"), time.Now()}); err != nil {
+			t.Fatalf("etags=%v: write() error = %v", etags, err)
+		}
+		if err := s2.write(ctx, content{[]byte("second"), time.Now()}); !errors.Is(err, ErrConflict) {
+			t.Errorf("etags=%v: write() error = %v, want ErrConflict", etags, err)
+		}
+	}
+}
+
+func TestWebDAVConflictCreated(t *testing.T) {
+	url := newWebDAVServer(t, true)
+	ctx := context.Background()
+	s := &webdavStorage{config: config.WebDAV{URL: url}}
+	if _, err := s.read(ctx); err != nil {
+		t.Fatalf("read() error = %v", err)
+	}
+	if err := (&webdavStorage{config: config.WebDAV{URL: url}}).write(ctx, content{[]byte("other"), time.Now()}); err != nil {
+		t.Fatalf("write() error = %v", err)
+	}
+	if err := s.write(ctx, content{[]byte("mine"), time.Now()}); !errors.Is(err, ErrConflict) {
+		t.Errorf("write() error = %v, want ErrConflict", err)
+	}
+}